@@ -2,6 +2,11 @@ package core
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,7 +20,111 @@ type HostInfo struct {
 	Service  string
 	Username string
 	Password string
-	Context  context.Context // 新增：支持上下文传递
+	Domain   string            // Windows 域名（-domain），用于 NTLM 认证
+	Context  context.Context   // 新增：支持上下文传递
+	Options  map[string]string // 插件选项（-o key=value）
+	Handler  ResultHandler     // 结果回调，为空时直接输出到终端
+	State    *ScanState        // 单次扫描的插件状态，为空时使用进程级状态
+}
+
+// ScanState 单次扫描中插件共享的状态（目标探测结果、已尝试的凭据等），不同扫描之间互不影响
+type ScanState struct {
+	caches sync.Map // 缓存名称 -> *sync.Map
+}
+
+// NewScanState 创建扫描状态，每次扫描一个
+func NewScanState() *ScanState {
+	return &ScanState{}
+}
+
+// Cache 返回指定名称的缓存，同一扫描中名称相同时返回同一个 map
+func (s *ScanState) Cache(name string) *sync.Map {
+	value, _ := s.caches.LoadOrStore(name, &sync.Map{})
+	return value.(*sync.Map)
+}
+
+// defaultScanState 未设置 State 时（如直接调用插件）使用的进程级状态
+var defaultScanState = NewScanState()
+
+// Cache 返回本次扫描中指定名称的插件缓存，插件通常以 host:port 为键
+func (info *HostInfo) Cache(name string) *sync.Map {
+	if info.State == nil {
+		return defaultScanState.Cache(name)
+	}
+	return info.State.Cache(name)
+}
+
+// ResultHandler 扫描结果处理函数
+type ResultHandler func(result *ScanResult)
+
+// Report 上报扫描结果，自动补全目标信息
+func (info *HostInfo) Report(result *ScanResult) {
+	if result.Host == "" {
+		result.Host = info.Host
+	}
+	if result.Port == 0 {
+		result.Port = info.Port
+	}
+	if result.Service == "" {
+		result.Service = info.Service
+	}
+	if result.Timestamp.IsZero() {
+		result.Timestamp = time.Now()
+	}
+
+	if info.Handler != nil {
+		info.Handler(result)
+		return
+	}
+	fmt.Println(result.String())
+}
+
+// Option 获取插件选项，未设置时返回默认值
+func (info *HostInfo) Option(key, def string) string {
+	if info.Options == nil {
+		return def
+	}
+	if value, exists := info.Options[key]; exists && value != "" {
+		return value
+	}
+	return def
+}
+
+// OptionBool 获取布尔类型的插件选项
+func (info *HostInfo) OptionBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(info.Option(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// OptionDuration 获取时间类型的插件选项（如 30s、2m）
+func (info *HostInfo) OptionDuration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(info.Option(key, ""))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// OptionList 获取逗号分隔的列表选项
+func (info *HostInfo) OptionList(key string, def []string) []string {
+	value := info.Option(key, "")
+	if value == "" {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
 }
 
 // ScanResult 扫描结果
@@ -26,13 +135,56 @@ type ScanResult struct {
 	Username  string            `json:"username"`
 	Password  string            `json:"password"`
 	Success   bool              `json:"success"`
-	VulnType  string            `json:"vuln_type"` // "unauth", "weak_password", "vuln"
+	VulnType  string            `json:"vuln_type"` // "unauth", "weak_password", "vuln", "info", "inconclusive"
 	Timestamp time.Time         `json:"timestamp"`
 	Duration  time.Duration     `json:"duration"`
 	Error     string            `json:"error,omitempty"`
 	Metadata  map[string]string `json:"metadata,omitempty"`
 }
 
+// String 格式化扫描结果，附带按键名排序的元数据
+func (r *ScanResult) String() string {
+	var b strings.Builder
+	marker := "[+]"
+	if r.VulnType == "inconclusive" {
+		marker = "[?]"
+	}
+	fmt.Fprintf(&b, "%s %s:%d %s", marker, r.Host, r.Port, r.Service)
+
+	switch r.VulnType {
+	case "unauth":
+		b.WriteString(" unauthorized access")
+	case "info":
+		// 无需凭据获取的服务信息
+		b.WriteString(" info")
+	case "inconclusive":
+		// 目标暂时拒绝，凭据未能验证
+		fmt.Fprintf(&b, " inconclusive %s:%s", r.Username, r.Password)
+	default:
+		fmt.Fprintf(&b, " %s:%s", r.Username, r.Password)
+	}
+
+	keys := make([]string, 0, len(r.Metadata))
+	for key := range r.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%s", key, r.Metadata[key])
+	}
+	if r.Error != "" {
+		fmt.Fprintf(&b, " error=%q", r.Error)
+	}
+
+	return b.String()
+}
+
+// ErrTargetBlocked 目标拒绝了扫描源（如因错误过多被封禁），引擎应停止对该目标的后续检测
+var ErrTargetBlocked = errors.New("target blocked")
+
+// ErrAccountLocked 账户已锁定，引擎应跳过该用户名的剩余密码
+var ErrAccountLocked = errors.New("account locked")
+
 // PluginFunc 插件函数类型
 type PluginFunc func(info *HostInfo) error
 
@@ -77,3 +229,14 @@ func (r *PluginRegistry) List() []string {
 
 // 全局插件注册表
 var GlobalRegistry = NewPluginRegistry()
+
+// BackoffError 目标暂时拒绝扫描源（如认证失败过多被临时列入黑名单），
+// 引擎应在 Delay 之后重试本次凭据，期间不应把结果视为密码错误
+type BackoffError struct {
+	Delay  time.Duration
+	Reason string
+}
+
+func (e *BackoffError) Error() string {
+	return fmt.Sprintf("target backoff %s: %s", e.Delay, e.Reason)
+}
@@ -0,0 +1,107 @@
+package ntlm
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// AV_PAIR 标识（MS-NLMP 2.2.2.1）
+const (
+	AvEOL             = 0x0000
+	AvNbComputerName  = 0x0001
+	AvNbDomainName    = 0x0002
+	AvDNSComputerName = 0x0003
+	AvDNSDomainName   = 0x0004
+	AvDNSTreeName     = 0x0005
+	AvFlags           = 0x0006
+	AvTimestamp       = 0x0007
+	AvSingleHost      = 0x0008
+	AvTargetName      = 0x0009
+	AvChannelBindings = 0x000A
+)
+
+// AvFlagMICPresent MsvAvFlags 中表示 AUTHENTICATE_MESSAGE 附带 MIC
+const AvFlagMICPresent = 0x00000002
+
+// AvPair 单个属性值对
+type AvPair struct {
+	ID    uint16
+	Value []byte
+}
+
+// AvPairs 保持服务端顺序的属性值对列表（不含 MsvAvEOL）
+type AvPairs []AvPair
+
+// ParseAvPairs 解析 TargetInfo 中的属性值对
+func ParseAvPairs(data []byte) (AvPairs, error) {
+	var pairs AvPairs
+	for len(data) >= 4 {
+		id := binary.LittleEndian.Uint16(data[0:2])
+		length := int(binary.LittleEndian.Uint16(data[2:4]))
+		if id == AvEOL {
+			return pairs, nil
+		}
+		if 4+length > len(data) {
+			return nil, errors.New("ntlm: av pair out of range")
+		}
+		pairs = append(pairs, AvPair{ID: id, Value: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("ntlm: truncated av pairs")
+	}
+	return pairs, nil
+}
+
+// Encode 编码为 TargetInfo，末尾追加 MsvAvEOL
+func (p AvPairs) Encode() []byte {
+	var out []byte
+	for _, pair := range p {
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint16(header[0:2], pair.ID)
+		binary.LittleEndian.PutUint16(header[2:4], uint16(len(pair.Value)))
+		out = append(out, header...)
+		out = append(out, pair.Value...)
+	}
+	return append(out, 0, 0, 0, 0)
+}
+
+// Get 返回指定属性的原始值，不存在时返回 nil
+func (p AvPairs) Get(id uint16) []byte {
+	for _, pair := range p {
+		if pair.ID == id {
+			return pair.Value
+		}
+	}
+	return nil
+}
+
+// String 返回字符串类型属性（UTF-16LE）的值
+func (p AvPairs) String(id uint16) string {
+	return decodeUTF16(p.Get(id))
+}
+
+func (p AvPairs) clone() AvPairs {
+	return append(AvPairs(nil), p...)
+}
+
+// set 设置属性值，已存在时替换
+func (p *AvPairs) set(id uint16, value []byte) {
+	for i := range *p {
+		if (*p)[i].ID == id {
+			(*p)[i].Value = value
+			return
+		}
+	}
+	*p = append(*p, AvPair{ID: id, Value: value})
+}
+
+// setFlags 在 MsvAvFlags 中追加标志位
+func (p *AvPairs) setFlags(flags uint32) {
+	value := make([]byte, 4)
+	if current := p.Get(AvFlags); len(current) == 4 {
+		flags |= binary.LittleEndian.Uint32(current)
+	}
+	binary.LittleEndian.PutUint32(value, flags)
+	p.set(AvFlags, value)
+}
@@ -0,0 +1,337 @@
+// Package ntlm 实现 NTLMv2 客户端认证（MS-NLMP），供 RDP、MSSQL 等需要 Windows 认证的插件复用
+package ntlm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// signature NTLM 消息签名
+const signature = "NTLMSSP\x00"
+
+// 消息类型
+const (
+	typeNegotiate    = 1
+	typeChallenge    = 2
+	typeAuthenticate = 3
+)
+
+// 协商标志（MS-NLMP 2.2.2.5）
+const (
+	NegotiateUnicode                 = 0x00000001
+	NegotiateOEM                     = 0x00000002
+	RequestTarget                    = 0x00000004
+	NegotiateSign                    = 0x00000010
+	NegotiateSeal                    = 0x00000020
+	NegotiateNTLM                    = 0x00000200
+	NegotiateAlwaysSign              = 0x00008000
+	NegotiateExtendedSessionSecurity = 0x00080000
+	NegotiateTargetInfo              = 0x00800000
+	NegotiateVersion                 = 0x02000000
+	Negotiate128                     = 0x20000000
+	NegotiateKeyExch                 = 0x40000000
+	Negotiate56                      = 0x80000000
+)
+
+// defaultFlags 客户端请求的协商标志
+const defaultFlags = NegotiateUnicode | RequestTarget | NegotiateSign | NegotiateSeal | NegotiateNTLM |
+	NegotiateAlwaysSign | NegotiateExtendedSessionSecurity | NegotiateTargetInfo | NegotiateVersion |
+	Negotiate128 | NegotiateKeyExch | Negotiate56
+
+// 消息头部长度
+const (
+	negotiateHeaderLen    = 40 // 含 Version
+	challengeMinLen       = 48
+	authenticateHeaderLen = 88 // 含 Version 和 MIC
+	micOffset             = 72
+)
+
+// clientVersion 客户端上报的版本（Windows 10，NTLMSSP_REVISION_W2K3）
+var clientVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15}
+
+// Version 对端上报的操作系统版本
+type Version struct {
+	Major uint8
+	Minor uint8
+	Build uint16
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Build)
+}
+
+// ChallengeMessage 服务端的 CHALLENGE_MESSAGE
+type ChallengeMessage struct {
+	Flags           uint32
+	ServerChallenge [8]byte
+	TargetName      string
+	TargetInfo      AvPairs
+	Version         *Version
+	raw             []byte
+}
+
+// ParseChallenge 解析 CHALLENGE_MESSAGE
+func ParseChallenge(data []byte) (*ChallengeMessage, error) {
+	if len(data) < challengeMinLen || string(data[:8]) != signature {
+		return nil, errors.New("ntlm: invalid challenge message")
+	}
+	if binary.LittleEndian.Uint32(data[8:12]) != typeChallenge {
+		return nil, errors.New("ntlm: unexpected message type")
+	}
+
+	msg := &ChallengeMessage{
+		Flags: binary.LittleEndian.Uint32(data[20:24]),
+		raw:   data,
+	}
+	copy(msg.ServerChallenge[:], data[24:32])
+
+	targetName, err := readField(data, 12)
+	if err != nil {
+		return nil, err
+	}
+	msg.TargetName = decodeUTF16(targetName)
+
+	targetInfo, err := readField(data, 40)
+	if err != nil {
+		return nil, err
+	}
+	if msg.TargetInfo, err = ParseAvPairs(targetInfo); err != nil {
+		return nil, err
+	}
+
+	if msg.Flags&NegotiateVersion != 0 && len(data) >= 56 {
+		msg.Version = &Version{
+			Major: data[48],
+			Minor: data[49],
+			Build: binary.LittleEndian.Uint16(data[50:52]),
+		}
+	}
+	return msg, nil
+}
+
+// Client NTLMv2 客户端，按 Negotiate → Authenticate 顺序使用
+type Client struct {
+	User        string
+	Password    string
+	Domain      string
+	Workstation string
+	TargetSPN   string // 写入 MsvAvTargetName，如 TERMSRV/host
+
+	negotiate  []byte
+	challenge  *ChallengeMessage
+	exportKey  []byte
+	flags      uint32
+	sessionMIC bool
+
+	// 以下字段仅用于已知答案测试，为空时随机生成或取当前时间
+	clientChallenge []byte
+	randomKey       []byte
+	timestamp       []byte
+}
+
+// Negotiate 生成 NEGOTIATE_MESSAGE
+func (c *Client) Negotiate() []byte {
+	msg := make([]byte, negotiateHeaderLen)
+	copy(msg, signature)
+	binary.LittleEndian.PutUint32(msg[8:12], typeNegotiate)
+	binary.LittleEndian.PutUint32(msg[12:16], defaultFlags)
+	// 域名和工作站字段为空，偏移指向消息末尾
+	binary.LittleEndian.PutUint32(msg[20:24], negotiateHeaderLen)
+	binary.LittleEndian.PutUint32(msg[28:32], negotiateHeaderLen)
+	copy(msg[32:40], clientVersion)
+
+	c.negotiate = msg
+	return msg
+}
+
+// Challenge 返回已处理的服务端挑战
+func (c *Client) Challenge() *ChallengeMessage {
+	return c.challenge
+}
+
+// Authenticate 根据服务端的 CHALLENGE_MESSAGE 生成 AUTHENTICATE_MESSAGE
+func (c *Client) Authenticate(challengeData []byte) ([]byte, error) {
+	challenge, err := ParseChallenge(challengeData)
+	if err != nil {
+		return nil, err
+	}
+	c.challenge = challenge
+	c.flags = challenge.Flags & defaultFlags
+
+	clientChallenge := c.clientChallenge
+	if clientChallenge == nil {
+		clientChallenge = randomBytes(8)
+	}
+
+	// 服务端提供时间戳时使用该时间戳，并通过 MsvAvFlags 声明附带 MIC
+	targetInfo := challenge.TargetInfo.clone()
+	timestamp := c.timestamp
+	if serverTime := targetInfo.Get(AvTimestamp); serverTime != nil {
+		timestamp = serverTime
+		targetInfo.setFlags(AvFlagMICPresent)
+		c.sessionMIC = true
+	}
+	if timestamp == nil {
+		timestamp = fileTime(time.Now())
+	}
+	if c.TargetSPN != "" {
+		targetInfo.set(AvTargetName, encodeUTF16(c.TargetSPN))
+	}
+
+	responseKey := NTOWFv2(c.Password, c.User, c.Domain)
+	ntResponse, lmResponse, sessionBaseKey := computeResponse(responseKey, challenge.ServerChallenge[:], clientChallenge, timestamp, targetInfo.Encode())
+	if c.sessionMIC {
+		// 附带 MIC 时 LmChallengeResponse 置零（MS-NLMP 3.1.5.1.2）
+		lmResponse = make([]byte, 24)
+	}
+
+	// 密钥交换：随机生成导出会话密钥，用会话基础密钥加密后发送
+	c.exportKey = sessionBaseKey
+	var encryptedKey []byte
+	if c.flags&NegotiateKeyExch != 0 {
+		c.exportKey = c.randomKey
+		if c.exportKey == nil {
+			c.exportKey = randomBytes(16)
+		}
+		encryptedKey = rc4Encrypt(sessionBaseKey, c.exportKey)
+	}
+
+	msg := c.buildAuthenticate(lmResponse, ntResponse, encryptedKey)
+	if c.sessionMIC {
+		mac := hmac.New(md5.New, c.exportKey)
+		mac.Write(c.negotiate)
+		mac.Write(challenge.raw)
+		mac.Write(msg)
+		copy(msg[micOffset:micOffset+16], mac.Sum(nil))
+	}
+	return msg, nil
+}
+
+// buildAuthenticate 按固定顺序写入负载：域名、用户名、工作站、LM 响应、NT 响应、会话密钥
+func (c *Client) buildAuthenticate(lmResponse, ntResponse, encryptedKey []byte) []byte {
+	header := make([]byte, authenticateHeaderLen)
+	copy(header, signature)
+	binary.LittleEndian.PutUint32(header[8:12], typeAuthenticate)
+
+	payload := new(bytes.Buffer)
+	putField := func(offset int, value []byte) {
+		binary.LittleEndian.PutUint16(header[offset:], uint16(len(value)))
+		binary.LittleEndian.PutUint16(header[offset+2:], uint16(len(value)))
+		binary.LittleEndian.PutUint32(header[offset+4:], uint32(authenticateHeaderLen+payload.Len()))
+		payload.Write(value)
+	}
+
+	putField(28, encodeUTF16(c.Domain))
+	putField(36, encodeUTF16(c.User))
+	putField(44, encodeUTF16(c.Workstation))
+	putField(12, lmResponse)
+	putField(20, ntResponse)
+	putField(52, encryptedKey)
+
+	binary.LittleEndian.PutUint32(header[60:64], c.flags)
+	copy(header[64:72], clientVersion)
+
+	return append(header, payload.Bytes()...)
+}
+
+// Session 认证完成后创建客户端方向的签名加密上下文
+func (c *Client) Session() (*Session, error) {
+	if c.exportKey == nil {
+		return nil, errors.New("ntlm: authentication not completed")
+	}
+	return newSession(c.exportKey, c.flags), nil
+}
+
+// NTOWFv2 计算 NTLMv2 响应密钥（LMOWFv2 与之相同）
+func NTOWFv2(password, user, domain string) []byte {
+	hash := md4.New()
+	hash.Write(encodeUTF16(password))
+	return hmacMD5(hash.Sum(nil), encodeUTF16(strings.ToUpper(user)+domain))
+}
+
+// computeResponse 计算 NTLMv2 和 LMv2 响应以及会话基础密钥（MS-NLMP 3.3.2）
+func computeResponse(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte, []byte) {
+	temp := new(bytes.Buffer)
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0}) // Responserversion、HiResponserversion、保留
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write(make([]byte, 4))
+	temp.Write(targetInfo)
+	temp.Write(make([]byte, 4))
+
+	ntProofStr := hmacMD5(responseKey, serverChallenge, temp.Bytes())
+	ntResponse := append(ntProofStr, temp.Bytes()...)
+	lmResponse := append(hmacMD5(responseKey, serverChallenge, clientChallenge), clientChallenge...)
+	sessionBaseKey := hmacMD5(responseKey, ntProofStr)
+
+	return ntResponse, lmResponse, sessionBaseKey
+}
+
+// readField 读取消息中的变长字段（长度、最大长度、偏移）
+func readField(data []byte, offset int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(data[offset:]))
+	start := int(binary.LittleEndian.Uint32(data[offset+4:]))
+	if length == 0 {
+		return nil, nil
+	}
+	if start+length > len(data) {
+		return nil, errors.New("ntlm: field out of range")
+	}
+	return data[start : start+length], nil
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func rc4Encrypt(key, data []byte) []byte {
+	cipher, _ := rc4.NewCipher(key)
+	out := make([]byte, len(data))
+	cipher.XORKeyStream(out, data)
+	return out
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// fileTime 转换为 Windows FILETIME（1601-01-01 起的 100 纳秒数）
+func fileTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(t.UnixNano()/100+116444736000000000))
+	return b
+}
+
+func encodeUTF16(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[i*2:], u)
+	}
+	return b
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
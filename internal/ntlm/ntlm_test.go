@@ -0,0 +1,237 @@
+package ntlm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// 已知答案取自 MS-NLMP 4.2.4（NTLMv2 Authentication）
+var (
+	testUser            = "User"
+	testDomain          = "Domain"
+	testPassword        = "Password"
+	testServerChallenge = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	testClientChallenge = bytes.Repeat([]byte{0xaa}, 8)
+	testRandomKey       = bytes.Repeat([]byte{0x55}, 16)
+	testTimestamp       = make([]byte, 8)
+	testChallengeFlags  = uint32(0xe28a8233)
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// buildTestChallenge 按 MS-NLMP 4.2.4.3 构建 CHALLENGE_MESSAGE
+func buildTestChallenge() []byte {
+	targetName := encodeUTF16("Server")
+	targetInfo := AvPairs{
+		{ID: AvNbDomainName, Value: encodeUTF16("Domain")},
+		{ID: AvNbComputerName, Value: encodeUTF16("Server")},
+	}.Encode()
+
+	msg := make([]byte, 56)
+	copy(msg, signature)
+	binary.LittleEndian.PutUint32(msg[8:], typeChallenge)
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint32(msg[16:], 56)
+	binary.LittleEndian.PutUint32(msg[20:], testChallengeFlags)
+	copy(msg[24:], testServerChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(56+len(targetName)))
+	copy(msg[48:], []byte{6, 0, 0x70, 0x17, 0, 0, 0, 15})
+	msg = append(msg, targetName...)
+	return append(msg, targetInfo...)
+}
+
+func newTestClient(t *testing.T) (*Client, []byte) {
+	t.Helper()
+	client := &Client{
+		User:            testUser,
+		Password:        testPassword,
+		Domain:          testDomain,
+		Workstation:     "COMPUTER",
+		clientChallenge: testClientChallenge,
+		randomKey:       testRandomKey,
+		timestamp:       testTimestamp,
+	}
+	client.Negotiate()
+	msg, err := client.Authenticate(buildTestChallenge())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, msg
+}
+
+func field(t *testing.T, msg []byte, offset int) []byte {
+	t.Helper()
+	value, err := readField(msg, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestNTOWFv2(t *testing.T) {
+	want := mustHex(t, "0c 86 8a 40 3b fd 7a 93 a3 00 1e f2 2e f0 2e 3f")
+	if got := NTOWFv2(testPassword, testUser, testDomain); !bytes.Equal(got, want) {
+		t.Fatalf("NTOWFv2 = %x, want %x", got, want)
+	}
+}
+
+func TestComputeResponse(t *testing.T) {
+	targetInfo := mustHex(t, "02 00 0c 00 44 00 6f 00 6d 00 61 00 69 00 6e 00 01 00 0c 00 53 00 65 00 72 00 76 00 65 00 72 00 00 00 00 00")
+	responseKey := NTOWFv2(testPassword, testUser, testDomain)
+	ntResponse, lmResponse, sessionBaseKey := computeResponse(responseKey, testServerChallenge, testClientChallenge, testTimestamp, targetInfo)
+
+	if want := mustHex(t, "68 cd 0a b8 51 e5 1c 96 aa bc 92 7b eb ef 6a 1c"); !bytes.Equal(ntResponse[:16], want) {
+		t.Errorf("NTProofStr = %x, want %x", ntResponse[:16], want)
+	}
+	if want := mustHex(t, "86 c3 50 97 ac 9c ec 10 25 54 76 4a 57 cc cc 19 aa aa aa aa aa aa aa aa"); !bytes.Equal(lmResponse, want) {
+		t.Errorf("LMv2 = %x, want %x", lmResponse, want)
+	}
+	if want := mustHex(t, "8d e4 0c ca db c1 4a 82 f1 5c b0 ad 0d e9 5c a3"); !bytes.Equal(sessionBaseKey, want) {
+		t.Errorf("SessionBaseKey = %x, want %x", sessionBaseKey, want)
+	}
+
+	temp := mustHex(t, "01 01 00 00 00 00 00 00 00 00 00 00 00 00 00 00 aa aa aa aa aa aa aa aa 00 00 00 00")
+	temp = append(append(temp, targetInfo...), 0, 0, 0, 0)
+	if !bytes.Equal(ntResponse[16:], temp) {
+		t.Errorf("NTLMv2 client challenge = %x, want %x", ntResponse[16:], temp)
+	}
+}
+
+func TestAuthenticateMessage(t *testing.T) {
+	_, msg := newTestClient(t)
+
+	if want := mustHex(t, "c5 da d2 54 4f c9 79 90 94 ce 1c e9 0b c9 d0 3e"); !bytes.Equal(field(t, msg, 52), want) {
+		t.Errorf("EncryptedRandomSessionKey = %x, want %x", field(t, msg, 52), want)
+	}
+	if want := mustHex(t, "68 cd 0a b8 51 e5 1c 96 aa bc 92 7b eb ef 6a 1c"); !bytes.Equal(field(t, msg, 20)[:16], want) {
+		t.Errorf("NTProofStr = %x, want %x", field(t, msg, 20)[:16], want)
+	}
+	if got := decodeUTF16(field(t, msg, 28)); got != testDomain {
+		t.Errorf("domain = %q, want %q", got, testDomain)
+	}
+	if got := decodeUTF16(field(t, msg, 36)); got != testUser {
+		t.Errorf("user = %q, want %q", got, testUser)
+	}
+	if mic := msg[micOffset : micOffset+16]; !bytes.Equal(mic, make([]byte, 16)) {
+		t.Errorf("MIC = %x, want zero without server timestamp", mic)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	client, _ := newTestClient(t)
+	session, err := client.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, sig := session.Seal(encodeUTF16("Plaintext"))
+	if want := mustHex(t, "54 e5 01 65 bf 19 36 dc 99 60 20 c1 81 1b 0f 06 fb 5f"); !bytes.Equal(sealed, want) {
+		t.Errorf("sealed = %x, want %x", sealed, want)
+	}
+	if want := mustHex(t, "01 00 00 00 7f b3 8e c5 c5 5d 49 76 00 00 00 00"); !bytes.Equal(sig, want) {
+		t.Errorf("signature = %x, want %x", sig, want)
+	}
+}
+
+func TestMICWithServerTimestamp(t *testing.T) {
+	challenge, err := ParseChallenge(buildTestChallenge())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.TargetName != "Server" || challenge.TargetInfo.String(AvNbDomainName) != "Domain" {
+		t.Fatalf("unexpected challenge: %+v", challenge)
+	}
+	if challenge.Version == nil || challenge.Version.String() != "6.0.6000" {
+		t.Fatalf("version = %v, want 6.0.6000", challenge.Version)
+	}
+
+	// 在 TargetInfo 中加入时间戳，客户端应设置 MsvAvFlags 并计算 MIC
+	challenge.TargetInfo.set(AvTimestamp, bytes.Repeat([]byte{0x11}, 8))
+	data := buildTestChallenge()[:56]
+	targetInfo := challenge.TargetInfo.Encode()
+	name := encodeUTF16("Server")
+	binary.LittleEndian.PutUint16(data[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(data[42:], uint16(len(targetInfo)))
+	data = append(append(data, name...), targetInfo...)
+
+	client := &Client{User: testUser, Password: testPassword, Domain: testDomain, clientChallenge: testClientChallenge, randomKey: testRandomKey}
+	negotiate := client.Negotiate()
+	msg, err := client.Authenticate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lm := field(t, msg, 12); !bytes.Equal(lm, make([]byte, 24)) {
+		t.Errorf("LmChallengeResponse = %x, want zeros", lm)
+	}
+
+	mic := append([]byte(nil), msg[micOffset:micOffset+16]...)
+	zeroed := append([]byte(nil), msg...)
+	copy(zeroed[micOffset:micOffset+16], make([]byte, 16))
+	if want := hmacMD5(testRandomKey, negotiate, data, zeroed); !bytes.Equal(mic, want) {
+		t.Errorf("MIC = %x, want %x", mic, want)
+	}
+
+	ntResponse := field(t, msg, 20)
+	pairs, err := ParseAvPairs(ntResponse[16+28:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := pairs.Get(AvFlags); len(flags) != 4 || binary.LittleEndian.Uint32(flags)&AvFlagMICPresent == 0 {
+		t.Errorf("MsvAvFlags = %x, want MIC present", flags)
+	}
+}
+
+func TestSPNEGOUnwrap(t *testing.T) {
+	challenge := buildTestChallenge()
+	if got, err := Unwrap(challenge); err != nil || !bytes.Equal(got, challenge) {
+		t.Fatalf("raw token: got %x, %v", got, err)
+	}
+
+	wrapped, err := WrapAuthenticate(challenge, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Unwrap(wrapped); err != nil || !bytes.Equal(got, challenge) {
+		t.Fatalf("spnego token: got %x, %v", got, err)
+	}
+
+	init, err := WrapNegotiate((&Client{}).Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if init[0] != 0x60 {
+		t.Fatalf("InitialContextToken tag = %#x, want 0x60", init[0])
+	}
+}
+
+func TestParseUser(t *testing.T) {
+	tests := []struct {
+		account, user, domain string
+	}{
+		{`CORP\alice`, "alice", "CORP"},
+		{"alice@corp.local", "alice", "corp.local"},
+		{"alice", "alice", ""},
+		{`.\administrator`, "administrator", "."},
+		{"@alice", "@alice", ""},
+	}
+	for _, tt := range tests {
+		user, domain := ParseUser(tt.account)
+		if user != tt.user || domain != tt.domain {
+			t.Errorf("ParseUser(%q) = %q, %q, want %q, %q", tt.account, user, domain, tt.user, tt.domain)
+		}
+	}
+}
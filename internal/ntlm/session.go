@@ -0,0 +1,78 @@
+package ntlm
+
+import (
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+)
+
+// 签名和加密密钥的派生常量（MS-NLMP 3.4.5）
+const (
+	clientSigningMagic = "session key to client-to-server signing key magic constant\x00"
+	clientSealingMagic = "session key to client-to-server sealing key magic constant\x00"
+)
+
+// Session 客户端方向的签名加密上下文（扩展会话安全）
+type Session struct {
+	signKey []byte
+	sealer  *rc4.Cipher
+	seqNum  uint32
+	keyExch bool
+}
+
+func newSession(exportKey []byte, flags uint32) *Session {
+	sealKey := exportKey
+	switch {
+	case flags&Negotiate128 != 0:
+	case flags&Negotiate56 != 0:
+		sealKey = exportKey[:7]
+	default:
+		sealKey = exportKey[:5]
+	}
+
+	sealer, _ := rc4.NewCipher(md5Sum(sealKey, []byte(clientSealingMagic)))
+	return &Session{
+		signKey: md5Sum(exportKey, []byte(clientSigningMagic)),
+		sealer:  sealer,
+		keyExch: flags&NegotiateKeyExch != 0,
+	}
+}
+
+// Seal 加密消息并计算签名（GSS_WrapEx），返回密文和 16 字节签名
+func (s *Session) Seal(message []byte) ([]byte, []byte) {
+	sealed := make([]byte, len(message))
+	s.sealer.XORKeyStream(sealed, message)
+	return sealed, s.sign(message)
+}
+
+// Wrap 返回 签名 + 密文，即 CredSSP 等协议中使用的格式
+func (s *Session) Wrap(message []byte) []byte {
+	sealed, sig := s.Seal(message)
+	return append(sig, sealed...)
+}
+
+// sign 计算消息签名并递增序号，签名校验和使用加密流继续加密
+func (s *Session) sign(message []byte) []byte {
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, s.seqNum)
+	s.seqNum++
+
+	checksum := hmacMD5(s.signKey, seq, message)[:8]
+	if s.keyExch {
+		s.sealer.XORKeyStream(checksum, checksum)
+	}
+
+	sig := make([]byte, 16)
+	binary.LittleEndian.PutUint32(sig[0:4], 1)
+	copy(sig[4:12], checksum)
+	copy(sig[12:16], seq)
+	return sig
+}
+
+func md5Sum(data ...[]byte) []byte {
+	hash := md5.New()
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package ntlm
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+)
+
+// 机制 OID（RFC 4178、MS-SPNG）
+var (
+	oidSPNEGO = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidNTLM   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+// negTokenInit SPNEGO 初始令牌，仅声明 NTLM 机制
+type negTokenInit struct {
+	MechTypes []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	MechToken []byte                  `asn1:"explicit,optional,tag:2"`
+}
+
+// negTokenResp SPNEGO 后续令牌
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// WrapNegotiate 将 NEGOTIATE_MESSAGE 封装为 SPNEGO NegTokenInit（InitialContextToken）
+func WrapNegotiate(token []byte) ([]byte, error) {
+	init, err := asn1.Marshal(negTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{oidNTLM},
+		MechToken: token,
+	})
+	if err != nil {
+		return nil, err
+	}
+	inner, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: init})
+	if err != nil {
+		return nil, err
+	}
+	mech, err := asn1.Marshal(oidSPNEGO)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(mech, inner...)})
+}
+
+// WrapAuthenticate 将 AUTHENTICATE_MESSAGE 封装为 SPNEGO NegTokenResp
+// session 不为空时附带 mechListMIC，签名会占用会话的一个序号
+func WrapAuthenticate(token []byte, session *Session) ([]byte, error) {
+	resp := negTokenResp{ResponseToken: token}
+	if session != nil {
+		mechList, err := asn1.Marshal([]asn1.ObjectIdentifier{oidNTLM})
+		if err != nil {
+			return nil, err
+		}
+		resp.MechListMIC = session.sign(mechList)
+	}
+
+	body, err := asn1.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: body})
+}
+
+// Unwrap 从服务端令牌中取出 NTLM 消息，同时接受原始 NTLM 消息和 SPNEGO NegTokenResp
+func Unwrap(token []byte) ([]byte, error) {
+	if bytes.HasPrefix(token, []byte(signature)) {
+		return token, nil
+	}
+
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(token, &outer); err != nil {
+		return nil, err
+	}
+	if outer.Class != asn1.ClassContextSpecific || outer.Tag != 1 {
+		return nil, errors.New("ntlm: unexpected spnego token")
+	}
+
+	var resp negTokenResp
+	if _, err := asn1.Unmarshal(outer.Bytes, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.SupportedMech) > 0 && !resp.SupportedMech.Equal(oidNTLM) {
+		return nil, errors.New("ntlm: server selected unsupported mechanism " + resp.SupportedMech.String())
+	}
+	if !bytes.HasPrefix(resp.ResponseToken, []byte(signature)) {
+		return nil, errors.New("ntlm: spnego token carries no ntlm message")
+	}
+	return resp.ResponseToken, nil
+}
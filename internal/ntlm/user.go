@@ -0,0 +1,14 @@
+package ntlm
+
+import "strings"
+
+// ParseUser 拆分域账户，支持 DOMAIN\user 和 user@domain 两种写法，未包含域名时 domain 为空
+func ParseUser(account string) (user, domain string) {
+	if before, after, found := strings.Cut(account, `\`); found {
+		return after, before
+	}
+	if i := strings.LastIndex(account, "@"); i > 0 {
+		return account[:i], account[i+1:]
+	}
+	return account, ""
+}
@@ -0,0 +1,226 @@
+package leo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// calculateTargetTimeout 动态计算单个目标的超时时间
+func calculateTargetTimeout(usernames, passwords []string, service string) time.Duration {
+	// 基础计算：每次尝试平均耗时
+	avgTimePerAttempt := 2 * time.Second // 平均每次尝试2秒
+	totalAttempts := len(usernames) * len(passwords)
+
+	// 考虑并发因子（假设可以并发3个连接）
+	concurrencyFactor := 3
+	if totalAttempts < concurrencyFactor {
+		concurrencyFactor = totalAttempts
+	}
+
+	estimatedTime := time.Duration(totalAttempts/concurrencyFactor) * avgTimePerAttempt
+
+	// 设置合理的边界
+	minTimeout := 1 * time.Minute
+	maxTimeout := 10 * time.Minute
+
+	if estimatedTime < minTimeout {
+		return minTimeout
+	}
+	if estimatedTime > maxTimeout {
+		return maxTimeout
+	}
+
+	return estimatedTime
+}
+
+// calculateGlobalTimeout 动态计算全局超时时间
+func calculateGlobalTimeout(targetCount, usernameCount, passwordCount, concurrency int) time.Duration {
+	// 基础计算
+	totalCombinations := targetCount * usernameCount * passwordCount
+	avgTimePerCombination := 2 * time.Second
+
+	// 考虑并发
+	estimatedTime := time.Duration(totalCombinations/concurrency) * avgTimePerCombination
+
+	// 添加缓冲时间（20%）
+	estimatedTime = time.Duration(float64(estimatedTime) * 1.2)
+
+	// 设置边界
+	minTimeout := 5 * time.Minute
+	maxTimeout := 2 * time.Hour
+
+	if estimatedTime < minTimeout {
+		return minTimeout
+	}
+	if estimatedTime > maxTimeout {
+		return maxTimeout
+	}
+
+	return estimatedTime
+}
+
+// prioritizeCredentials 对凭据进行优先级排序（使用您原有的密码逻辑）
+func prioritizeCredentials(usernames, passwords []string, service string) ([]string, []string) {
+	// 获取服务特定的优先级顺序（从默认列表中获取）
+	defaultUsernames := DefaultUsernames(service)
+	defaultPasswords := DefaultPasswords(service)
+
+	// 用户名优先级排序
+	prioritizedUsernames := []string{}
+	for _, username := range defaultUsernames {
+		if contains(usernames, username) {
+			prioritizedUsernames = append(prioritizedUsernames, username)
+		}
+	}
+	// 添加其他用户名
+	for _, username := range usernames {
+		if !contains(prioritizedUsernames, username) {
+			prioritizedUsernames = append(prioritizedUsernames, username)
+		}
+	}
+
+	// 密码优先级排序
+	prioritizedPasswords := []string{}
+	for _, password := range defaultPasswords {
+		if contains(passwords, password) {
+			prioritizedPasswords = append(prioritizedPasswords, password)
+		}
+	}
+	// 添加其他密码
+	for _, password := range passwords {
+		if !contains(prioritizedPasswords, password) {
+			prioritizedPasswords = append(prioritizedPasswords, password)
+		}
+	}
+
+	return prioritizedUsernames, prioritizedPasswords
+}
+
+// contains 检查切片是否包含指定元素
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTarget 解析目标地址，返回主机和端口
+func parseTarget(target, service string) (string, int) {
+	parts := strings.Split(target, ":")
+	if len(parts) == 2 {
+		if port, err := strconv.Atoi(parts[1]); err == nil {
+			return parts[0], port
+		}
+	}
+	return target, DefaultPort(service)
+}
+
+// DefaultPort 服务的默认端口，未知服务返回 80
+func DefaultPort(service string) int {
+	ports := map[string]int{
+		"mysql":       3306,
+		"mariadb":     3306,
+		"tidb":        4000,
+		"oceanbase":   2881,
+		"doris":       9030,
+		"starrocks":   9030,
+		"dameng":      5236,
+		"mssql":       1433,
+		"ftp":         21,
+		"redis":       6379,
+		"oracle":      1521,
+		"postgresql":  5432,
+		"opengauss":   5432,
+		"kingbase":    54321,
+		"greenplum":   5432,
+		"cockroachdb": 26257,
+		"mongodb":     27017,
+		"ssh":         22,
+		"rdp":         3389,
+		"telnet":      23,
+		"vnc":         5900,
+	}
+	if port, exists := ports[service]; exists {
+		return port
+	}
+	return 80
+}
+
+// DefaultUsernames 服务的默认用户名字典
+func DefaultUsernames(service string) []string {
+	usernames := map[string][]string{
+		"ftp":         {"anonymous", "ftp", "admin", "root", "user"},
+		"mysql":       {"root", "admin", "mysql", "user", "test"},
+		"mariadb":     {"root", "admin", "mysql", "user", "test"},
+		"tidb":        {"root", "admin"},
+		"oceanbase":   {"root@sys", "root", "admin", "proxyro"},
+		"doris":       {"root", "admin"},
+		"starrocks":   {"root", "admin"},
+		"ssh":         {"root", "admin", "ubuntu", "centos", "user"},
+		"postgresql":  {"postgres", "admin", "root", "user"},
+		"opengauss":   {"gaussdb", "omm", "gauss", "admin"},
+		"kingbase":    {"system", "sao", "sso", "kingbase"},
+		"greenplum":   {"gpadmin", "postgres", "admin"},
+		"cockroachdb": {"root", "admin"},
+		"mongodb":     {"admin", "root", "mongodb", "user"},
+		"redis":       {"admin", "root", "redis", "user"},
+		"oracle":      {"sys", "system", "oracle", "admin", "root"},
+		"mssql":       {"sa", "admin", "administrator", "root"},
+		"dameng":      {"SYSDBA", "SYSAUDITOR", "SYSSSO", "SYS", "SYSDBO"},
+		"rdp":         {"administrator", "admin", "guest"},
+		"telnet":      {"admin", "root", "user", "administrator", "guest", "cisco", "manager", "operator", "support", "test"},
+	}
+	if users, exists := usernames[service]; exists {
+		return users
+	}
+	return []string{"admin", "root", "user"}
+}
+
+// DefaultPasswords 服务的默认密码字典
+func DefaultPasswords(service string) []string {
+	switch service {
+	case "mysql", "mariadb":
+		return []string{"", "root", "123456", "password", "admin", "mysql"}
+	case "tidb":
+		return []string{"", "root", "123456", "password", "admin", "tidb"}
+	case "oceanbase":
+		return []string{"", "root", "123456", "password", "admin", "oceanbase"}
+	case "doris", "starrocks":
+		return []string{"", "root", "123456", "password", "admin", "doris", "starrocks"}
+	case "dameng":
+		return []string{"", "SYSDBA", "SYSDBA001", "123456", "SYSAUDITOR", "SYSSSO", "SYS", "SYSDBO"}
+	case "mssql":
+		return []string{"", "sa", "123456", "password", "admin"}
+	case "oracle":
+		return []string{"", "oracle", "123456", "password", "admin", "manager"}
+	case "postgresql":
+		return []string{"", "postgres", "123456", "password", "admin"}
+	case "opengauss":
+		return []string{"", "Gauss@123", "Gauss_234", "Enmo@123", "openGauss@123", "123456", "password"}
+	case "kingbase":
+		return []string{"", "123456", "12345678ab", "manager", "kingbase", "system", "password"}
+	case "greenplum":
+		return []string{"", "gpadmin", "changeme", "123456", "password"}
+	case "cockroachdb":
+		return []string{"", "root", "123456", "password", "admin"}
+	case "redis":
+		return []string{"", "123456", "password", "redis"}
+	case "mongodb":
+		return []string{"", "123456", "password", "admin", "mongo"}
+	case "ftp":
+		return []string{"", "ftp", "123456", "password", "admin"}
+	case "ssh":
+		return []string{"", "123456", "password", "admin", "root", "123123", "111111", "000000", "888888", "666666", "ubuntu", "centos", "raspberry", "toor", "pass", "qwerty", "abc123"}
+	case "rdp":
+		return []string{"", "123456", "password", "admin", "administrator", "123123", "111111", "000000", "888888", "666666", "P@ssw0rd", "Password123", "admin123", "root123", "guest"}
+	case "telnet":
+		return []string{"", "123456", "password", "admin", "root", "123123", "111111", "000000", "888888", "666666", "cisco", "manager", "public", "private", "enable", "secret", "guest", "test", "support", "operator"}
+	case "vnc":
+		return []string{"", "123456", "password", "admin", "vnc", "123123", "111111", "000000", "888888", "666666", "secret", "pass", "qwerty", "abc123", "root123", "admin123"}
+	default:
+		return []string{"", "123456", "password", "admin", "root"}
+	}
+}
@@ -0,0 +1,57 @@
+package leo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultPort(t *testing.T) {
+	ports := map[string]int{
+		"mysql":       3306,
+		"mariadb":     3306,
+		"tidb":        4000,
+		"oceanbase":   2881,
+		"doris":       9030,
+		"starrocks":   9030,
+		"dameng":      5236,
+		"mssql":       1433,
+		"ftp":         21,
+		"redis":       6379,
+		"oracle":      1521,
+		"postgresql":  5432,
+		"opengauss":   5432,
+		"kingbase":    54321,
+		"greenplum":   5432,
+		"cockroachdb": 26257,
+		"mongodb":     27017,
+		"ssh":         22,
+		"rdp":         3389,
+		"telnet":      23,
+		"vnc":         5900,
+	}
+
+	// 每个内置服务都有默认端口，不落到未知服务的 80
+	for _, service := range Services() {
+		if strings.HasPrefix(service, "test-") {
+			continue // scanner_test.go 注册的测试插件
+		}
+		if _, ok := ports[service]; !ok {
+			t.Errorf("registered service %s missing from the test table", service)
+		}
+	}
+
+	for service, port := range ports {
+		if got := DefaultPort(service); got != port {
+			t.Errorf("DefaultPort(%s) = %d, want %d", service, got, port)
+		}
+		if host, got := parseTarget("192.168.1.10", service); host != "192.168.1.10" || got != port {
+			t.Errorf("parseTarget(bare host, %s) = %s:%d, want port %d", service, host, got, port)
+		}
+		if host, got := parseTarget("192.168.1.10:2222", service); host != "192.168.1.10" || got != 2222 {
+			t.Errorf("parseTarget(host:port, %s) = %s:%d, want explicit port 2222", service, host, got)
+		}
+	}
+	if got := DefaultPort("unknown"); got != 80 {
+		t.Errorf("DefaultPort(unknown) = %d, want 80", got)
+	}
+}
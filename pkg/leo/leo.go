@@ -0,0 +1,50 @@
+// Package leo 提供可嵌入的网络服务弱口令扫描 API，命令行工具 cmd/leo 基于该包实现。
+//
+//	scanner, err := leo.NewScanner(leo.Options{Service: "ssh", Concurrency: 50})
+//	if err != nil {
+//		return err
+//	}
+//	for result := range scanner.Scan(ctx, []string{"192.168.1.10:22"}, leo.Credentials{Usernames: []string{"root"}}) {
+//		fmt.Println(result.String())
+//	}
+package leo
+
+import (
+	"sort"
+
+	"github.com/zan8in/leo/internal/core"
+	// 导入插件包以注册内置插件
+	_ "github.com/zan8in/leo/plugins"
+)
+
+// Result 扫描结果
+type Result = core.ScanResult
+
+// HostInfo 插件的检测目标、凭据及选项，插件通过 Report 上报结果
+type HostInfo = core.HostInfo
+
+// PluginFunc 插件函数：空凭据时检测未授权访问，否则验证 Username / Password；
+// 返回 nil 表示发现结果，返回 ErrTargetBlocked、ErrAccountLocked 或 *BackoffError 影响后续调度
+type PluginFunc = core.PluginFunc
+
+// BackoffError 目标暂时拒绝扫描源，扫描器在 Delay 后重试同一凭据
+type BackoffError = core.BackoffError
+
+var (
+	// ErrTargetBlocked 目标拒绝了扫描源，停止检测该目标
+	ErrTargetBlocked = core.ErrTargetBlocked
+	// ErrAccountLocked 账户已锁定，跳过该用户名的剩余密码
+	ErrAccountLocked = core.ErrAccountLocked
+)
+
+// Register 注册插件，与已有服务同名时替换该插件
+func Register(service string, plugin PluginFunc) {
+	core.GlobalRegistry.Register(service, plugin)
+}
+
+// Services 返回已注册的服务名称（按字母排序）
+func Services() []string {
+	services := core.GlobalRegistry.List()
+	sort.Strings(services)
+	return services
+}
@@ -0,0 +1,368 @@
+package leo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// 默认配置
+const (
+	defaultConcurrency = 25
+	defaultTimeout     = 1500 * time.Millisecond
+)
+
+// maxBackoffRetries 同一凭据因目标临时拒绝而等待重试的最大次数
+const maxBackoffRetries = 3
+
+// Options 扫描配置
+type Options struct {
+	Service       string            // 服务类型，如 mysql、ssh、rdp
+	Concurrency   int               // 同时检测的目标数，默认 25
+	Timeout       time.Duration     // 单次连接超时，默认 1500ms
+	Retries       int               // 重试次数
+	FullScan      bool              // 发现结果后继续检测该目标的剩余凭据
+	TargetTimeout time.Duration     // 单个目标的最大扫描时间，0 表示按字典大小自动计算
+	GlobalTimeout time.Duration     // 整个扫描的最大时间，0 表示自动计算
+	Domain        string            // Windows 域名，用于 RDP、MSSQL 等 NTLM 认证
+	PluginOptions map[string]string // 插件选项，同命令行 -o key=value
+
+	// Progress 每完成一个目标回调一次，调用是串行的
+	Progress func(Progress)
+	// Logf 输出调度过程的详细日志（超时、封禁、冷却等），为空时不输出
+	Logf func(format string, args ...any)
+}
+
+// Credentials 凭据字典，为空时使用服务的默认字典
+type Credentials struct {
+	Usernames []string
+	Passwords []string
+}
+
+// Progress 扫描进度
+type Progress struct {
+	Completed int
+	Total     int
+	Target    string // 刚完成的目标
+}
+
+// Scanner 针对单个服务的扫描器，可重复调用 Scan，每次扫描的插件状态相互独立
+type Scanner struct {
+	opts   Options
+	plugin PluginFunc
+}
+
+// NewScanner 创建扫描器，服务未注册时返回错误
+func NewScanner(opts Options) (*Scanner, error) {
+	plugin, exists := core.GlobalRegistry.Get(opts.Service)
+	if !exists {
+		return nil, fmt.Errorf("service '%s' not supported", opts.Service)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	return &Scanner{opts: opts, plugin: plugin}, nil
+}
+
+// Scan 扫描目标（host 或 host:port），结果通过返回的通道输出，扫描结束或 ctx 取消后通道关闭。
+// 调用方应读完通道或取消 ctx，否则插件上报结果时会阻塞
+func (s *Scanner) Scan(ctx context.Context, targets []string, creds Credentials) <-chan Result {
+	usernames, passwords := creds.Usernames, creds.Passwords
+	if len(usernames) == 0 {
+		usernames = DefaultUsernames(s.opts.Service)
+	}
+	if len(passwords) == 0 {
+		passwords = DefaultPasswords(s.opts.Service)
+	}
+	usernames, passwords = prioritizeCredentials(usernames, passwords, s.opts.Service)
+
+	targetTimeout := s.opts.TargetTimeout
+	if targetTimeout == 0 {
+		targetTimeout = calculateTargetTimeout(usernames, passwords, s.opts.Service)
+	}
+	globalTimeout := s.opts.GlobalTimeout
+	if globalTimeout == 0 {
+		globalTimeout = calculateGlobalTimeout(len(targets), len(usernames), len(passwords), s.opts.Concurrency)
+	}
+
+	s.logf("[*] Usernames: %d", len(usernames))
+	s.logf("[*] Passwords: %d", len(passwords))
+	s.logf("[*] Target timeout: %v", targetTimeout)
+	s.logf("[*] Global timeout: %v", globalTimeout)
+
+	results := newResultStream()
+	go func() {
+		defer results.close()
+		s.run(ctx, targets, usernames, passwords, targetTimeout, globalTimeout, results.emit)
+	}()
+	return results.out
+}
+
+// run 执行扫描：每个目标一个协程，先检测未授权访问，再按用户名、密码顺序检测弱口令
+func (s *Scanner) run(ctx context.Context, targets, usernames, passwords []string, targetTimeout, globalTimeout time.Duration, emit core.ResultHandler) {
+	// 创建全局上下文
+	globalCtx, globalCancel := context.WithTimeout(ctx, globalTimeout)
+	defer globalCancel()
+
+	sem := make(chan struct{}, s.opts.Concurrency)
+	var wg sync.WaitGroup
+	foundTargets := make(map[string]bool)
+	var mu sync.Mutex
+	// 插件的目标探测缓存只在本次扫描内有效，重复扫描同一目标时重新探测
+	state := core.NewScanState()
+
+	// 进度统计
+	var (
+		completedTargets int
+		progressMu       sync.Mutex
+	)
+
+	for _, target := range targets {
+		host, port := parseTarget(target, s.opts.Service)
+
+		// 检查全局上下文是否已取消
+		select {
+		case <-globalCtx.Done():
+			if ctx.Err() != nil {
+				s.logf("[!] Scan cancelled, stopping scan")
+			} else {
+				s.logf("[!] Global timeout reached, stopping scan")
+			}
+			return
+		default:
+		}
+
+		// 检查是否已找到该目标的弱口令（非全扫描模式）
+		if !s.opts.FullScan {
+			mu.Lock()
+			if foundTargets[fmt.Sprintf("%s:%d", host, port)] {
+				mu.Unlock()
+				continue
+			}
+			mu.Unlock()
+		}
+
+		wg.Add(1)
+		go func(target, h string, p int) {
+			defer wg.Done()
+			defer func() {
+				progressMu.Lock()
+				defer progressMu.Unlock()
+				completedTargets++
+				if s.opts.Progress != nil {
+					s.opts.Progress(Progress{Completed: completedTargets, Total: len(targets), Target: target})
+				}
+			}()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// 为每个目标创建独立的超时上下文
+			targetCtx, targetCancel := context.WithTimeout(globalCtx, targetTimeout)
+			defer targetCancel()
+
+			// 优先检测未授权访问
+			info := &core.HostInfo{
+				Host:     h,
+				Port:     p,
+				Timeout:  s.opts.Timeout,
+				Retries:  s.opts.Retries,
+				Service:  s.opts.Service,
+				Username: "",
+				Password: "",
+				Domain:   s.opts.Domain,
+				Context:  targetCtx, // 传递目标级上下文
+				Options:  s.opts.PluginOptions,
+				Handler:  emit,
+				State:    state,
+			}
+
+			markFound := func() {
+				if !s.opts.FullScan {
+					mu.Lock()
+					foundTargets[fmt.Sprintf("%s:%d", h, p)] = true
+					mu.Unlock()
+				}
+			}
+
+			err := s.attempt(targetCtx, info, sem)
+			if err == nil {
+				// 发现未授权访问，标记该目标已找到
+				markFound()
+				return
+			}
+			if errors.Is(err, core.ErrTargetBlocked) || isBackoff(err) {
+				s.logf("[!] Target %s:%d blocked, skipping: %v", h, p, err)
+				return
+			}
+
+			// 未授权访问失败，进行弱口令检测
+			for _, username := range usernames {
+				// 检查目标上下文是否已取消
+				select {
+				case <-targetCtx.Done():
+					s.logf("[!] Target %s:%d timeout reached", h, p)
+					return
+				default:
+				}
+
+				// 检查是否已找到该目标的弱口令
+				if !s.opts.FullScan {
+					mu.Lock()
+					if foundTargets[fmt.Sprintf("%s:%d", h, p)] {
+						mu.Unlock()
+						break
+					}
+					mu.Unlock()
+				}
+
+				for _, password := range passwords {
+					// 再次检查上下文
+					select {
+					case <-targetCtx.Done():
+						return
+					default:
+					}
+
+					info.Username = username
+					info.Password = password
+
+					err := s.attempt(targetCtx, info, sem)
+					if err == nil {
+						// 找到弱口令，标记该目标
+						if !s.opts.FullScan {
+							markFound()
+							break
+						}
+					} else if errors.Is(err, core.ErrTargetBlocked) || isBackoff(err) {
+						// 目标已封禁扫描源（或多次冷却后仍拒绝），继续尝试只会浪费请求
+						s.logf("[!] Target %s:%d blocked, skipping: %v", h, p, err)
+						return
+					} else if errors.Is(err, core.ErrAccountLocked) {
+						// 账户已锁定，跳过该用户名的剩余密码
+						s.logf("[!] %s:%d account %s locked, skipping", h, p, username)
+						break
+					}
+				}
+			}
+		}(target, host, port)
+	}
+
+	// 等待所有goroutine完成
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logf("[*] Scan completed successfully")
+	case <-globalCtx.Done():
+		if ctx.Err() != nil {
+			s.logf("[!] Scan cancelled")
+		} else {
+			s.logf("[!] Scan terminated due to global timeout")
+		}
+	case <-time.After(globalTimeout + 30*time.Second): // 额外30秒缓冲
+		s.logf("[!] Force terminating scan - some goroutines may be stuck")
+	}
+}
+
+// attempt 执行一次检测；插件返回 BackoffError 时释放并发槽位，等待冷却后重试同一凭据。
+// 多次冷却后仍被拒绝或目标超时时，将该凭据作为不确定结果上报并返回 BackoffError
+func (s *Scanner) attempt(ctx context.Context, info *core.HostInfo, sem chan struct{}) error {
+	for retry := 0; ; retry++ {
+		err := s.plugin(info)
+		var backoff *core.BackoffError
+		if !errors.As(err, &backoff) {
+			return err
+		}
+
+		if retry < maxBackoffRetries {
+			s.logf("[!] %s:%d backing off %s: %s", info.Host, info.Port, backoff.Delay, backoff.Reason)
+			if cooldown(ctx, backoff.Delay, sem) {
+				continue
+			}
+		}
+
+		info.Report(&core.ScanResult{
+			Service:  info.Service,
+			Username: info.Username,
+			Password: info.Password,
+			VulnType: "inconclusive",
+			Error:    backoff.Reason,
+		})
+		return err
+	}
+}
+
+func (s *Scanner) logf(format string, args ...any) {
+	if s.opts.Logf != nil {
+		s.opts.Logf(format, args...)
+	}
+}
+
+// cooldown 冷却期间让出并发槽位，目标超时返回 false
+func cooldown(ctx context.Context, delay time.Duration, sem chan struct{}) bool {
+	<-sem
+	defer func() { sem <- struct{}{} }()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// isBackoff 判断是否为多次冷却后仍未恢复的临时拒绝
+func isBackoff(err error) bool {
+	var backoff *core.BackoffError
+	return errors.As(err, &backoff)
+}
+
+// resultStream 结果通道；扫描因超时提前结束时仍在运行的插件协程可能继续上报，关闭后丢弃这些结果
+type resultStream struct {
+	out    chan Result
+	abort  chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newResultStream() *resultStream {
+	return &resultStream{
+		out:   make(chan Result),
+		abort: make(chan struct{}),
+	}
+}
+
+// emit 发送结果，调用方停止读取且扫描已结束时放弃发送
+func (r *resultStream) emit(result *core.ScanResult) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.out <- *result:
+	case <-r.abort:
+	}
+}
+
+func (r *resultStream) close() {
+	close(r.abort)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	close(r.out)
+}
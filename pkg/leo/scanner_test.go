@@ -0,0 +1,227 @@
+package leo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zan8in/leo/pkg/leo"
+)
+
+var errBadPassword = errors.New("bad password")
+
+// collect 读完扫描结果通道
+func collect(t *testing.T, results <-chan leo.Result) []leo.Result {
+	t.Helper()
+	var got []leo.Result
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return got
+			}
+			got = append(got, result)
+		case <-timeout:
+			t.Fatal("result channel not closed")
+		}
+	}
+}
+
+func newTestScanner(t *testing.T, opts leo.Options) *leo.Scanner {
+	t.Helper()
+	opts.TargetTimeout = 5 * time.Second
+	opts.Logf = t.Logf
+	scanner, err := leo.NewScanner(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner
+}
+
+func TestScanResults(t *testing.T) {
+	var attempts atomic.Int32
+	leo.Register("test-weak", func(info *leo.HostInfo) error {
+		attempts.Add(1)
+		if info.Password != "good" {
+			return errBadPassword
+		}
+		info.Report(&leo.Result{Username: info.Username, Password: info.Password, Success: true, VulnType: "weak_password"})
+		return nil
+	})
+
+	var (
+		mu       sync.Mutex
+		progress []leo.Progress
+	)
+	scanner := newTestScanner(t, leo.Options{
+		Service: "test-weak",
+		Progress: func(p leo.Progress) {
+			mu.Lock()
+			progress = append(progress, p)
+			mu.Unlock()
+		},
+	})
+	if _, err := leo.NewScanner(leo.Options{Service: "test-missing"}); err == nil {
+		t.Error("NewScanner accepted an unregistered service")
+	}
+
+	targets := []string{"10.0.0.1:1000", "10.0.0.2:2000", "10.0.0.3:3000"}
+	creds := leo.Credentials{Usernames: []string{"admin"}, Passwords: []string{"wrong", "good", "unused"}}
+	results := collect(t, scanner.Scan(context.Background(), targets, creds))
+
+	if len(results) != len(targets) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(targets), results)
+	}
+	seen := make(map[int]bool)
+	for _, result := range results {
+		if result.Service != "test-weak" || result.Username != "admin" || result.Password != "good" || !result.Success {
+			t.Errorf("unexpected result %+v", result)
+		}
+		seen[result.Port] = true
+	}
+	if !seen[1000] || !seen[2000] || !seen[3000] {
+		t.Errorf("results for ports %v, want 1000, 2000 and 3000", seen)
+	}
+	// 每个目标：未授权检测、wrong、good，找到后不再尝试 unused
+	if got := attempts.Load(); got != 9 {
+		t.Errorf("plugin called %d times, want 9", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(progress) != len(targets) {
+		t.Fatalf("got %d progress callbacks, want %d", len(progress), len(targets))
+	}
+	for i, p := range progress {
+		if p.Completed != i+1 || p.Total != len(targets) {
+			t.Errorf("progress[%d] = %+v", i, p)
+		}
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	started := make(chan struct{}, 1)
+	leo.Register("test-hang", func(info *leo.HostInfo) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-info.Context.Done()
+		return info.Context.Err()
+	})
+
+	scanner := newTestScanner(t, leo.Options{Service: "test-hang"})
+	ctx, cancel := context.WithCancel(context.Background())
+	results := scanner.Scan(ctx, []string{"10.0.0.1:1000", "10.0.0.2:2000"}, leo.Credentials{
+		Usernames: []string{"admin"},
+		Passwords: []string{"admin"},
+	})
+
+	<-started
+	cancel()
+	if got := collect(t, results); len(got) != 0 {
+		t.Errorf("got results after cancel: %+v", got)
+	}
+}
+
+func TestScanCancelWithoutReading(t *testing.T) {
+	// 插件不断上报结果，调用方只读一个就取消，通道仍应关闭
+	leo.Register("test-flood", func(info *leo.HostInfo) error {
+		info.Report(&leo.Result{Username: info.Username, Password: info.Password, Success: true, VulnType: "weak_password"})
+		return nil
+	})
+
+	scanner := newTestScanner(t, leo.Options{Service: "test-flood", FullScan: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	results := scanner.Scan(ctx, []string{"10.0.0.1:1000", "10.0.0.2:2000"}, leo.Credentials{
+		Usernames: []string{"admin", "root"},
+		Passwords: []string{"admin", "123456", "password"},
+	})
+
+	<-results
+	cancel()
+	collect(t, results)
+}
+
+func TestScanBackoff(t *testing.T) {
+	// 前两次临时拒绝，冷却后重试同一凭据成功
+	var retried atomic.Int32
+	leo.Register("test-backoff", func(info *leo.HostInfo) error {
+		if info.Password != "good" {
+			return errBadPassword
+		}
+		if retried.Add(1) <= 2 {
+			return &leo.BackoffError{Delay: 10 * time.Millisecond, Reason: "too many connections"}
+		}
+		info.Report(&leo.Result{Username: info.Username, Password: info.Password, Success: true, VulnType: "weak_password"})
+		return nil
+	})
+
+	creds := leo.Credentials{Usernames: []string{"admin"}, Passwords: []string{"good"}}
+	results := collect(t, newTestScanner(t, leo.Options{Service: "test-backoff"}).Scan(context.Background(), []string{"10.0.0.1:1000"}, creds))
+	if len(results) != 1 || results[0].VulnType != "weak_password" || results[0].Password != "good" {
+		t.Fatalf("results = %+v, want one weak_password result", results)
+	}
+	if got := retried.Load(); got != 3 {
+		t.Errorf("credential tried %d times, want 3", got)
+	}
+
+	// 始终拒绝：多次冷却后上报不确定结果，并跳过该目标的剩余凭据
+	var (
+		mu    sync.Mutex
+		tried []string
+	)
+	leo.Register("test-blocked", func(info *leo.HostInfo) error {
+		if info.Username == "" {
+			return errBadPassword
+		}
+		mu.Lock()
+		tried = append(tried, info.Password)
+		mu.Unlock()
+		return &leo.BackoffError{Delay: 10 * time.Millisecond, Reason: "host blocked"}
+	})
+
+	creds = leo.Credentials{Usernames: []string{"admin"}, Passwords: []string{"first", "second"}}
+	results = collect(t, newTestScanner(t, leo.Options{Service: "test-blocked"}).Scan(context.Background(), []string{"10.0.0.1:1000"}, creds))
+	if len(results) != 1 {
+		t.Fatalf("results = %+v, want one inconclusive result", results)
+	}
+	result := results[0]
+	if result.VulnType != "inconclusive" || result.Success || result.Password != "first" || result.Error != "host blocked" {
+		t.Errorf("result = %+v, want inconclusive for password first", result)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tried) != 4 {
+		t.Errorf("tried %v, want the first password 4 times", tried)
+	}
+	for _, password := range tried {
+		if password != "first" {
+			t.Errorf("tried %q after the target was given up", password)
+		}
+	}
+}
+
+func TestScanPluginState(t *testing.T) {
+	// 插件按目标缓存探测结果：同一次扫描只探测一次，再次扫描重新探测
+	var probes atomic.Int32
+	leo.Register("test-state", func(info *leo.HostInfo) error {
+		if _, loaded := info.Cache("probe").LoadOrStore(info.Host, true); !loaded {
+			probes.Add(1)
+		}
+		return errBadPassword
+	})
+
+	scanner := newTestScanner(t, leo.Options{Service: "test-state"})
+	creds := leo.Credentials{Usernames: []string{"admin", "root"}, Passwords: []string{"admin", "123456"}}
+	for scan := 1; scan <= 2; scan++ {
+		collect(t, scanner.Scan(context.Background(), []string{"10.0.0.1:1000"}, creds))
+		if got := probes.Load(); got != int32(scan) {
+			t.Errorf("after scan %d: target probed %d times, want %d", scan, got, scan)
+		}
+	}
+}
@@ -6,12 +6,25 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
 	"time"
 
 	_ "gitee.com/chunanyong/dm"
 	"github.com/zan8in/leo/internal/core"
 )
 
+// 达梦错误码
+const (
+	dmErrInvalidLogon  = -2501 // 用户名或密码错误
+	dmErrAccountLocked = -2504 // 用户已被锁定
+)
+
+// dmCodeRegexp 达梦错误信息中的错误码，如 "[-2501]:用户名或密码错误"、"Error -2501: ..."、"错误号: -2501"
+var dmCodeRegexp = regexp.MustCompile(`(?:\[|Error |错误号[:：]\s*)(-\d{4,5})`)
+
 // DamengScan 达梦数据库扫描函数（参考fscan设计）
 func DamengScan(info *core.HostInfo) error {
 	if info.Port == 0 {
@@ -48,10 +61,7 @@ func DamengScan(info *core.HostInfo) error {
 	default:
 	}
 
-	// 达梦数据库连接字符串格式
-	dsn := fmt.Sprintf("dm://%s:%s@%s:%d", info.Username, info.Password, info.Host, info.Port)
-
-	db, err := sql.Open("dm", dsn)
+	db, err := sql.Open("dm", damengDSN(info, timeout))
 	if err != nil {
 		return err
 	}
@@ -65,11 +75,79 @@ func DamengScan(info *core.HostInfo) error {
 	}
 
 	// 使用请求级context进行连接测试
-	err = db.PingContext(requestCtx)
+	if err := classifyDamengError(db.PingContext(requestCtx)); err != nil {
+		return err
+	}
+
+	// 认证成功，输出结果
+	info.Report(&core.ScanResult{
+		Service:  "dameng",
+		Username: info.Username,
+		Password: info.Password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: damengServerMetadata(requestCtx, db),
+	})
+	return nil
+}
+
+// damengDSN 构建连接字符串，凭据中的 @ / : 等字符由 net/url 转义
+// 驱动的 connectTimeout、socketTimeout 单位为毫秒，保证目标不可达或无响应时按超时返回
+func damengDSN(info *core.HostInfo, timeout time.Duration) string {
+	millis := strconv.FormatInt(timeout.Milliseconds(), 10)
+
+	query := url.Values{}
+	query.Set("connectTimeout", millis)
+	query.Set("socketTimeout", millis)
+
+	dsn := &url.URL{
+		Scheme:   "dm",
+		User:     url.UserPassword(info.Username, info.Password),
+		Host:     net.JoinHostPort(info.Host, strconv.Itoa(info.Port)),
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// damengServerMetadata 登录后查询数据库版本及当前用户是否拥有 DBA 角色
+func damengServerMetadata(ctx context.Context, db *sql.DB) map[string]string {
+	metadata := make(map[string]string)
+
+	var banner string
+	if err := db.QueryRowContext(ctx, "SELECT BANNER FROM V$VERSION WHERE ROWNUM = 1").Scan(&banner); err == nil {
+		metadata["version"] = banner
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM USER_ROLE_PRIVS WHERE GRANTED_ROLE = 'DBA'").Scan(&count); err == nil {
+		metadata["dba"] = strconv.FormatBool(count > 0)
+		if count > 0 {
+			metadata["severity"] = "high"
+		}
+	}
+
+	return metadata
+}
+
+// classifyDamengError 根据达梦错误码对认证失败分类
+func classifyDamengError(err error) error {
 	if err == nil {
-		// 认证成功，输出结果
-		fmt.Printf("[+] %s:%d dameng %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+		return nil
 	}
+
+	match := dmCodeRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	code, _ := strconv.Atoi(match[1])
+
+	switch code {
+	case dmErrInvalidLogon:
+		return fmt.Errorf("dameng invalid username/password: %v", err)
+	case dmErrAccountLocked:
+		return fmt.Errorf("%w: %v", core.ErrAccountLocked, err)
+	}
+
 	return err
 }
 
@@ -1,15 +1,23 @@
 package plugins
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"net"
+	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/jlaffaye/ftp"
 	"github.com/zan8in/leo/internal/core"
 )
 
+const (
+	ftpStatusTLSRequired = 534 // 安全策略要求加密的响应码
+	ftpMaxListing        = 20  // 匿名访问时记录的目录项上限
+)
+
 // FtpScan FTP扫描函数（参考fscan设计）
 func FtpScan(info *core.HostInfo) error {
 	if info.Port == 0 {
@@ -18,10 +26,7 @@ func FtpScan(info *core.HostInfo) error {
 
 	// 优先检测匿名访问（类似fscan的FtpUnauth）
 	if info.Username == "" && info.Password == "" {
-		if err := ftpAnonymous(info); err == nil {
-			fmt.Printf("[+] %s:%d ftp anonymous access\n", info.Host, info.Port)
-			return nil // 发现匿名访问，停止进一步检测
-		}
+		return ftpAnonymous(info)
 	}
 
 	// 进行认证检测
@@ -43,44 +48,19 @@ func ftpAnonymous(info *core.HostInfo) error {
 		timeout = 3 * time.Second
 	}
 
-	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
-
 	// 创建带超时的context用于单个请求
 	requestCtx, requestCancel := context.WithTimeout(ctx, timeout)
 	defer requestCancel()
 
-	// 先测试TCP连接
-	var dialer net.Dialer
-	conn, err := dialer.DialContext(requestCtx, "tcp", addr)
-	if err != nil {
-		return err
-	}
-	conn.Close()
-
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
-	// 创建FTP连接
-	ftpConn, err := ftp.Dial(addr, ftp.DialWithTimeout(timeout))
+	// 探测服务并确定传输模式（每个目标一次）
+	target, err := probeFtpTarget(ctx, info, timeout)
 	if err != nil {
 		return err
 	}
-	defer ftpConn.Quit()
 
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
-	// 尝试匿名登录
-	err = ftpConn.Login("anonymous", "anonymous@example.com")
-	if err != nil {
+	// 依次尝试常见的匿名登录方式
+	anonymous := [][2]string{{"anonymous", "anonymous@example.com"}, {"anonymous", ""}, {"ftp", ""}}
+	for _, cred := range anonymous {
 		// 检查context是否已取消
 		select {
 		case <-ctx.Done():
@@ -88,34 +68,39 @@ func ftpAnonymous(info *core.HostInfo) error {
 		default:
 		}
 
-		// 尝试另一种匿名登录方式
-		err = ftpConn.Login("anonymous", "")
+		ftpConn, err := ftpLogin(requestCtx, info, target, cred[0], cred[1], timeout)
 		if err != nil {
-			// 检查context是否已取消
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+			continue
+		}
 
-			// 尝试ftp用户
-			err = ftpConn.Login("ftp", "")
-			if err != nil {
-				return err
+		// 验证匿名访问权限 - 尝试列出目录
+		entries, err := ftpConn.List("/")
+		if err != nil {
+			ftpConn.Quit()
+			return err
+		}
+
+		metadata := ftpMetadata(target)
+		metadata["listing"] = ftpFormatListing(entries)
+		if info.OptionBool("write-test", false) {
+			for key, value := range ftpWriteTest(ftpConn) {
+				metadata[key] = value
 			}
 		}
-	}
+		ftpConn.Quit()
 
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+		info.Report(&core.ScanResult{
+			Service:  "ftp",
+			Username: cred[0],
+			Password: cred[1],
+			Success:  true,
+			VulnType: "unauth",
+			Metadata: metadata,
+		})
+		return nil // 发现匿名访问，停止进一步检测
 	}
 
-	// 验证匿名访问权限 - 尝试列出目录
-	_, err = ftpConn.List("/")
-	return err
+	return fmt.Errorf("ftp anonymous login rejected")
 }
 
 // ftpAuth 认证检测
@@ -133,19 +118,15 @@ func ftpAuth(info *core.HostInfo) error {
 		timeout = 5 * time.Second
 	}
 
-	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
-
 	// 创建带超时的context用于单个请求
 	requestCtx, requestCancel := context.WithTimeout(ctx, timeout)
 	defer requestCancel()
 
-	// 先测试TCP连接
-	var dialer net.Dialer
-	conn, err := dialer.DialContext(requestCtx, "tcp", addr)
+	// 探测服务并确定传输模式（每个目标一次）
+	target, err := probeFtpTarget(ctx, info, timeout)
 	if err != nil {
 		return err
 	}
-	conn.Close()
 
 	// 检查context是否已取消
 	select {
@@ -160,25 +141,80 @@ func ftpAuth(info *core.HostInfo) error {
 		ftpTimeout = 5 * time.Second // 强制限制FTP连接超时为5秒
 	}
 
-	ftpConn, err := ftp.Dial(addr, ftp.DialWithTimeout(ftpTimeout))
+	// 尝试登录
+	ftpConn, err := ftpLogin(requestCtx, info, target, info.Username, info.Password, ftpTimeout)
 	if err != nil {
 		return err
 	}
-	defer ftpConn.Quit()
+	ftpConn.Quit()
+
+	info.Report(&core.ScanResult{
+		Service:  "ftp",
+		Username: info.Username,
+		Password: info.Password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: ftpMetadata(target),
+	})
+	return nil
+}
 
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+// isFtpTLSRequired 判断登录失败是否因服务端要求加密
+// USER 阶段被拒绝时 ftp 库只返回响应文本，因此同时按文本判断
+func isFtpTLSRequired(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == ftpStatusTLSRequired {
+		return true
 	}
 
-	// 尝试登录
-	err = ftpConn.Login(info.Username, info.Password)
-	if err == nil {
-		fmt.Printf("[+] %s:%d ftp %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+	message := strings.ToUpper(err.Error())
+	return strings.Contains(message, "TLS") || strings.Contains(message, "SSL") || strings.Contains(message, "ENCRYPTION")
+}
+
+// ftpMetadata 转换为结果元数据
+func ftpMetadata(target *ftpTarget) map[string]string {
+	metadata := map[string]string{
+		"tls": target.Mode(),
+	}
+	if target.Banner != "" {
+		metadata["banner"] = target.Banner
+	}
+	if target.System != "" {
+		metadata["system"] = target.System
+	}
+	return metadata
+}
+
+// ftpFormatListing 格式化根目录列表，最多记录 ftpMaxListing 项
+func ftpFormatListing(entries []*ftp.Entry) string {
+	var names []string
+	for _, entry := range entries {
+		if len(names) == ftpMaxListing {
+			break
+		}
+		name := entry.Name
+		if entry.Type == ftp.EntryTypeFolder {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	return fmt.Sprintf("%s (%d entries)", strings.Join(names, ","), len(entries))
+}
+
+// ftpWriteTest 上传唯一命名的空文件后立即删除，验证匿名用户是否可写
+// 仅在指定 write-test=true 时执行
+func ftpWriteTest(ftpConn *ftp.ServerConn) map[string]string {
+	name := fmt.Sprintf("leo_write_test_%d.tmp", time.Now().UnixNano())
+	if err := ftpConn.Stor(name, bytes.NewReader(nil)); err != nil {
+		return map[string]string{"writable": "false"}
+	}
+
+	// 匿名可写属于高危配置
+	metadata := map[string]string{"writable": "true", "severity": "high"}
+	if err := ftpConn.Delete(name); err != nil {
+		metadata["note"] = fmt.Sprintf("failed to delete test file %s: %v", name, err)
 	}
-	return err
+	return metadata
 }
 
 // 注册插件
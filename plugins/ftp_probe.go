@@ -0,0 +1,203 @@
+package plugins
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// FTP 传输安全模式
+const (
+	ftpModePlain    = "plain"
+	ftpModeExplicit = "explicit" // 明文连接后通过 AUTH TLS 升级
+	ftpModeImplicit = "implicit" // 连接建立即 TLS，默认端口 990
+)
+
+// ftpTarget 单个目标的 FTP 服务探测结果
+type ftpTarget struct {
+	once     sync.Once
+	mu       sync.Mutex
+	err      error
+	mode     string
+	auto     bool        // 是否允许自动升级到 explicit 模式
+	idle     *ftpSession // 登录失败后留待复用的控制连接
+	Banner   string
+	System   string // SYST 响应
+	Features map[string]string
+}
+
+// probeFtpTarget 读取欢迎信息、FEAT、SYST 并确定传输模式，结果按目标缓存
+func probeFtpTarget(ctx context.Context, info *core.HostInfo, timeout time.Duration) (*ftpTarget, error) {
+	key := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	// 探测结果在一次扫描内按 host:port 缓存，保证每个目标只探测一次
+	value, _ := info.Cache("ftp").LoadOrStore(key, &ftpTarget{})
+	target := value.(*ftpTarget)
+
+	target.once.Do(func() {
+		target.err = target.discover(ctx, info, timeout)
+		// 目标扫描结束（目标级context结束）时关闭复用的控制连接
+		if info.Context != nil {
+			context.AfterFunc(info.Context, target.closeIdle)
+		}
+	})
+	return target, target.err
+}
+
+// Mode 返回当前使用的传输模式
+func (t *ftpTarget) Mode() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mode
+}
+
+// upgrade 服务端要求加密时切换到 explicit 模式，返回是否发生切换
+func (t *ftpTarget) upgrade() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.auto || t.mode != ftpModePlain {
+		return false
+	}
+	t.mode = ftpModeExplicit
+	return true
+}
+
+// discover 按 tls 选项确定传输模式，auto 模式下 FEAT 声明 AUTH TLS 时自动升级
+// 选项：tls=auto|off|explicit|implicit（默认 auto，990 端口使用 implicit）
+func (t *ftpTarget) discover(ctx context.Context, info *core.HostInfo, timeout time.Duration) error {
+	switch strings.ToLower(info.Option("tls", "auto")) {
+	case "implicit":
+		t.mode = ftpModeImplicit
+	case "explicit":
+		t.mode = ftpModeExplicit
+	case "off", "false", "plain":
+		t.mode = ftpModePlain
+	default:
+		t.mode = ftpModePlain
+		t.auto = true
+		if info.Port == 990 {
+			t.mode = ftpModeImplicit
+			t.auto = false
+		}
+	}
+
+	var implicitTLS *tls.Config
+	if t.mode == ftpModeImplicit {
+		implicitTLS = ftpTLSConfig(info.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	conn, err := ftpProbeDial(ctx, addr, implicitTLS, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	_, banner, err := text.ReadResponse(220)
+	if err != nil {
+		return err
+	}
+	t.Banner = strings.ReplaceAll(banner, "\n", " ")
+	t.Features = ftpReadFeatures(text)
+	if system, err := ftpProbeCommand(text, "SYST", 215); err == nil {
+		t.System = system
+	}
+
+	// FEAT 声明支持 AUTH TLS 时验证升级是否可用，失败则继续使用明文
+	if t.auto && strings.Contains(strings.ToUpper(t.Features["AUTH"]), "TLS") {
+		if err := ftpProbeAuthTLS(text, conn, info.Host); err == nil {
+			t.mode = ftpModeExplicit
+		}
+		return nil
+	}
+
+	text.Cmd("QUIT")
+	return nil
+}
+
+// ftpProbeDial 建立探测连接，implicitTLS 不为空（implicit 模式）时直接进行 TLS 握手
+func ftpProbeDial(ctx context.Context, addr string, implicitTLS *tls.Config, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if implicitTLS == nil {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, implicitTLS)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// ftpReadFeatures 发送 FEAT 并解析服务端支持的扩展命令
+func ftpReadFeatures(text *textproto.Conn) map[string]string {
+	features := make(map[string]string)
+
+	id, err := text.Cmd("FEAT")
+	if err != nil {
+		return features
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+
+	code, message, err := text.ReadResponse(-1)
+	if err != nil || code != 211 {
+		return features
+	}
+
+	// 多行响应中以空格开头的行为扩展命令，如 " AUTH TLS"
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		name, desc, _ := strings.Cut(strings.TrimSpace(line), " ")
+		features[strings.ToUpper(name)] = desc
+	}
+	return features
+}
+
+// ftpProbeCommand 发送单条命令并读取响应文本
+func ftpProbeCommand(text *textproto.Conn, command string, expectCode int) (string, error) {
+	id, err := text.Cmd("%s", command)
+	if err != nil {
+		return "", err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+
+	_, message, err := text.ReadResponse(expectCode)
+	return message, err
+}
+
+// ftpProbeAuthTLS 发送 AUTH TLS 并完成握手，验证 explicit 模式可用
+func ftpProbeAuthTLS(text *textproto.Conn, conn net.Conn, host string) error {
+	if _, err := ftpProbeCommand(text, "AUTH TLS", 234); err != nil {
+		return err
+	}
+
+	return tls.Client(conn, ftpTLSConfig(host)).Handshake()
+}
+
+// ftpTLSConfig 扫描场景下目标多为自签名证书，不校验证书
+// 控制连接与数据连接共用同一配置，会话缓存使数据连接可以复用控制连接的 TLS 会话（vsftpd require_ssl_reuse）
+func ftpTLSConfig(host string) *tls.Config {
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+}
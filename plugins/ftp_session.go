@@ -0,0 +1,202 @@
+package plugins
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/zan8in/leo/internal/core"
+)
+
+// ftpStatusClosing 服务端即将关闭控制连接（如登录失败次数过多）
+const ftpStatusClosing = 421
+
+// ftpSession FTP 控制连接，登录失败（530）后可继续尝试下一组凭据
+type ftpSession struct {
+	conn    *ftp.ServerConn
+	netConn net.Conn // 底层连接，用于设置每次登录的超时
+}
+
+// login 在当前控制连接上尝试一组凭据
+func (s *ftpSession) login(username, password string, timeout time.Duration) error {
+	s.netConn.SetDeadline(time.Now().Add(timeout))
+	if err := s.conn.Login(username, password); err != nil {
+		return err
+	}
+
+	// 登录成功后为后续的目录列表等操作重新计时
+	s.netConn.SetDeadline(time.Now().Add(timeout))
+	return nil
+}
+
+// close 关闭控制连接
+func (s *ftpSession) close() {
+	s.conn.Quit()
+}
+
+// acquire 取出目标的空闲控制连接，没有时新建，返回连接是否为复用
+func (t *ftpTarget) acquire(ctx context.Context, info *core.HostInfo, timeout time.Duration) (*ftpSession, bool, error) {
+	t.mu.Lock()
+	session := t.idle
+	t.idle = nil
+	mode := t.mode
+	t.mu.Unlock()
+
+	if session != nil {
+		return session, true, nil
+	}
+
+	session, err := ftpDial(ctx, info, mode, timeout)
+	return session, false, err
+}
+
+// release 归还登录失败但仍可用的控制连接
+func (t *ftpTarget) release(session *ftpSession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		session.close()
+		return
+	}
+	t.idle = session
+}
+
+// closeIdle 目标扫描结束时关闭空闲的控制连接
+func (t *ftpTarget) closeIdle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		t.idle.close()
+		t.idle = nil
+	}
+}
+
+// ftpLogin 复用目标的控制连接尝试登录，登录成功的连接由调用方关闭
+// 服务端要求加密时自动升级到 explicit 模式，复用的连接被服务端关闭时使用新连接重试
+func ftpLogin(ctx context.Context, info *core.HostInfo, target *ftpTarget, username, password string, timeout time.Duration) (*ftp.ServerConn, error) {
+	session, reused, err := target.acquire(ctx, info, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.login(username, password, timeout)
+	if err == nil {
+		return session.conn, nil
+	}
+
+	switch {
+	case isFtpTLSRequired(err) && target.upgrade():
+		session.close()
+	case ftpSessionReusable(err):
+		target.release(session)
+		return nil, err
+	case !reused:
+		session.close()
+		return nil, err
+	default:
+		// 复用的连接已被服务端关闭，本次结果无效
+		session.close()
+	}
+
+	session, _, err = target.acquire(ctx, info, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.login(username, password, timeout); err != nil {
+		if ftpSessionReusable(err) {
+			target.release(session)
+		} else {
+			session.close()
+		}
+		return nil, err
+	}
+	return session.conn, nil
+}
+
+// ftpDial 按传输模式建立FTP控制连接
+func ftpDial(ctx context.Context, info *core.HostInfo, mode string, timeout time.Duration) (*ftpSession, error) {
+	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
+
+	var tlsConfig, implicitTLS *tls.Config
+	if mode != ftpModePlain {
+		tlsConfig = ftpTLSConfig(info.Host)
+	}
+	if mode == ftpModeImplicit {
+		implicitTLS = tlsConfig
+	}
+
+	// 自行建立底层连接以便控制每次登录的超时，implicit 模式下直接完成 TLS 握手
+	netConn, err := ftpProbeDial(ctx, addr, implicitTLS, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// 库使用同一个 dialFunc 建立控制连接和数据连接：第一次调用返回已建立的控制连接，
+	// 之后的调用（PASV/EPSV）建立新的数据连接
+	var control sync.Once
+	dialFunc := func(network, address string) (net.Conn, error) {
+		first := false
+		control.Do(func() { first = true })
+		if first {
+			return netConn, nil
+		}
+		return ftpDialData(network, address, tlsConfig, timeout)
+	}
+
+	options := []ftp.DialOption{ftp.DialWithDialFunc(dialFunc)}
+	switch mode {
+	case ftpModeImplicit:
+		options = append(options, ftp.DialWithTLS(tlsConfig))
+	case ftpModeExplicit:
+		options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+	}
+
+	ftpConn, err := ftp.Dial(addr, options...)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return &ftpSession{conn: ftpConn, netConn: netConn}, nil
+}
+
+// ftpDialData 建立数据连接，加密模式下登录时已发送 PROT P，数据连接同样使用 TLS
+// 与库的默认行为一致，TLS 握手在首次读写时进行
+func ftpDialData(network, address string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	return tls.Client(conn, tlsConfig), nil
+}
+
+// ftpSessionReusable 判断登录失败后控制连接是否仍可继续使用
+func ftpSessionReusable(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return false
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == ftpStatusClosing {
+		return false
+	}
+
+	var protocolErr textproto.ProtocolError
+	return !errors.As(err, &protocolErr)
+}
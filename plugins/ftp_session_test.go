@@ -0,0 +1,173 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// fakeFtpServer 允许匿名登录的最小 FTP 服务端，数据连接使用被动模式
+type fakeFtpServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	stored   []string // STOR 上传的文件
+	deleted  []string // DELE 删除的文件
+}
+
+func newFakeFtpServer(t *testing.T) *fakeFtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeFtpServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeFtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeFtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var data net.Listener
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+	// transfer 在被动模式监听上接受数据连接
+	transfer := func(fn func(net.Conn)) {
+		if data == nil {
+			reply("425 use PASV or EPSV first")
+			return
+		}
+		reply("150 opening data connection")
+		dataConn, err := data.Accept()
+		data.Close()
+		data = nil
+		if err != nil {
+			reply("425 cannot open data connection")
+			return
+		}
+		fn(dataConn)
+		dataConn.Close()
+		reply("226 transfer complete")
+	}
+
+	reply("220 fake ftpd ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch strings.ToUpper(command) {
+		case "FEAT":
+			reply("211-Features:\r\n EPSV\r\n PASV\r\n211 End")
+		case "SYST":
+			reply("215 UNIX Type: L8")
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			reply("230 login successful")
+		case "TYPE", "OPTS":
+			reply("200 ok")
+		case "EPSV", "PASV":
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 cannot listen")
+				continue
+			}
+			port := data.Addr().(*net.TCPAddr).Port
+			if strings.ToUpper(command) == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256)
+			}
+		case "LIST":
+			transfer(func(c net.Conn) {
+				io.WriteString(c, "drwxr-xr-x    2 0        0            4096 Jan 01 00:00 pub\r\n")
+				io.WriteString(c, "-rw-r--r--    1 0        0            1024 Jan 01 00:00 readme.txt\r\n")
+			})
+		case "STOR":
+			transfer(func(c net.Conn) { io.Copy(io.Discard, c) })
+			s.mu.Lock()
+			s.stored = append(s.stored, arg)
+			s.mu.Unlock()
+		case "DELE":
+			s.mu.Lock()
+			s.deleted = append(s.deleted, arg)
+			s.mu.Unlock()
+			reply("250 deleted")
+		case "QUIT":
+			reply("221 goodbye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func TestFtpAnonymousListing(t *testing.T) {
+	for _, writeTest := range []bool{false, true} {
+		server := newFakeFtpServer(t)
+
+		var results []*core.ScanResult
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		info := &core.HostInfo{
+			Host:    "127.0.0.1",
+			Port:    server.port(),
+			Timeout: 2 * time.Second,
+			Service: "ftp",
+			Context: ctx,
+			Options: map[string]string{"write-test": fmt.Sprint(writeTest)},
+			Handler: func(result *core.ScanResult) { results = append(results, result) },
+		}
+		err := FtpScan(info)
+		cancel()
+		if err != nil {
+			t.Fatalf("write-test=%v: FtpScan: %v", writeTest, err)
+		}
+		if len(results) != 1 || results[0].VulnType != "unauth" {
+			t.Fatalf("write-test=%v: results = %+v, want one unauth result", writeTest, results)
+		}
+
+		metadata := results[0].Metadata
+		if want := "pub/,readme.txt (2 entries)"; metadata["listing"] != want {
+			t.Errorf("write-test=%v: listing = %q, want %q", writeTest, metadata["listing"], want)
+		}
+		if !writeTest {
+			continue
+		}
+		if metadata["writable"] != "true" {
+			t.Errorf("writable = %q, want true", metadata["writable"])
+		}
+		server.mu.Lock()
+		if len(server.stored) != 1 || len(server.deleted) != 1 || server.stored[0] != server.deleted[0] {
+			t.Errorf("stored %v, deleted %v, want the same test file", server.stored, server.deleted)
+		}
+		server.mu.Unlock()
+	}
+}
@@ -2,7 +2,11 @@ package plugins
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zan8in/leo/internal/core"
@@ -34,8 +38,13 @@ func MongodbScan(info *core.HostInfo) error {
 
 	// 优先检测未授权访问（类似fscan的MongodbUnauth）
 	if info.Username == "" && info.Password == "" {
-		if err := mongodbUnauth(info, ctx); err == nil {
-			fmt.Printf("[+] %s:%d mongodb unauthorized access\n", info.Host, info.Port)
+		if evidence, err := mongodbUnauth(info, ctx); err == nil {
+			info.Report(&core.ScanResult{
+				Service:  "mongodb",
+				Success:  true,
+				VulnType: "unauth",
+				Metadata: evidence,
+			})
 			return nil // 发现未授权访问，停止进一步检测
 		}
 	}
@@ -44,8 +53,8 @@ func MongodbScan(info *core.HostInfo) error {
 	return mongodbAuth(info, ctx)
 }
 
-// mongodbUnauth 检测未授权访问
-func mongodbUnauth(info *core.HostInfo, parentCtx context.Context) error {
+// mongodbUnauth 检测未授权访问，成功时返回数据暴露的证据
+func mongodbUnauth(info *core.HostInfo, parentCtx context.Context) (map[string]string, error) {
 	timeout := info.Timeout
 	if timeout == 0 {
 		timeout = 3 * time.Second
@@ -58,14 +67,12 @@ func mongodbUnauth(info *core.HostInfo, parentCtx context.Context) error {
 	// 检查context是否已取消
 	select {
 	case <-parentCtx.Done():
-		return parentCtx.Err()
+		return nil, parentCtx.Err()
 	default:
 	}
 
 	// 无认证连接URI
-	uri := fmt.Sprintf("mongodb://%s:%d/?connectTimeoutMS=%d&serverSelectionTimeoutMS=%d",
-		info.Host, info.Port,
-		int(timeout.Milliseconds()), int(timeout.Milliseconds()))
+	uri := mongodbURI(info, nil, "", timeout)
 
 	clientOptions := options.Client().ApplyURI(uri)
 	clientOptions.SetConnectTimeout(timeout)
@@ -73,56 +80,156 @@ func mongodbUnauth(info *core.HostInfo, parentCtx context.Context) error {
 
 	client, err := mongo.Connect(requestCtx, clientOptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer client.Disconnect(requestCtx)
 
 	// 检查context是否已取消
 	select {
 	case <-parentCtx.Done():
-		return parentCtx.Err()
+		return nil, parentCtx.Err()
 	default:
 	}
 
 	// 快速连接测试
 	if err = client.Ping(requestCtx, nil); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 检查context是否已取消
 	select {
 	case <-parentCtx.Done():
-		return parentCtx.Err()
+		return nil, parentCtx.Err()
 	default:
 	}
 
+	// 证据收集使用独立的超时，避免连接阶段耗尽时间后将超时误判为拒绝
+	evidenceCtx, evidenceCancel := context.WithTimeout(parentCtx, timeout)
+	defer evidenceCancel()
+
 	// 尝试列出数据库（未授权访问的关键验证）
-	_, err = client.ListDatabaseNames(requestCtx, bson.D{})
-	return err
+	databases, err := client.ListDatabases(evidenceCtx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	return mongodbEvidence(evidenceCtx, client, databases), nil
+}
+
+// mongodbEvidence 收集未授权访问的证据：数据库列表、版本以及敏感命令是否可执行
+func mongodbEvidence(ctx context.Context, client *mongo.Client, databases mongo.ListDatabasesResult) map[string]string {
+	evidence := make(map[string]string)
+
+	// 数据库名称及大小，最多记录20个
+	names := make([]string, 0, len(databases.Databases))
+	for i, db := range databases.Databases {
+		if i >= 20 {
+			names = append(names, fmt.Sprintf("...(%d more)", len(databases.Databases)-i))
+			break
+		}
+		names = append(names, fmt.Sprintf("%s(%s)", db.Name, formatBytes(db.SizeOnDisk)))
+	}
+	evidence["databases"] = strings.Join(names, ",")
+	evidence["total_size"] = formatBytes(databases.TotalSize)
+
+	admin := client.Database("admin")
+
+	// 服务端版本
+	var buildInfo bson.M
+	if err := admin.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err == nil {
+		if version, ok := buildInfo["version"].(string); ok {
+			evidence["version"] = version
+		}
+	}
+
+	// 启动参数（可能包含配置文件路径、密钥文件等）
+	evidence["getCmdLineOpts"] = mongodbCommandAllowed(ctx, admin, bson.D{{Key: "getCmdLineOpts", Value: 1}})
+
+	// 用户信息
+	var usersInfo struct {
+		Users []bson.M `bson:"users"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "usersInfo", Value: 1}}).Decode(&usersInfo); err == nil {
+		evidence["usersInfo"] = fmt.Sprintf("allowed(%d users)", len(usersInfo.Users))
+	} else {
+		evidence["usersInfo"] = mongodbCommandStatus(err)
+	}
+
+	return evidence
+}
+
+// mongodbCommandAllowed 判断管理命令是否允许执行
+func mongodbCommandAllowed(ctx context.Context, db *mongo.Database, command bson.D) string {
+	return mongodbCommandStatus(db.RunCommand(ctx, command).Err())
+}
+
+// mongodbCommandStatus 将命令执行结果归类为 allowed、timeout 或 denied
+func mongodbCommandStatus(err error) string {
+	switch {
+	case err == nil:
+		return "allowed"
+	case mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		return "timeout"
+	default:
+		return "denied"
+	}
 }
 
-// mongodbAuth 认证检测
+// formatBytes 将字节数格式化为易读的大小
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// mongodbAuth 认证检测，依次尝试每个 authSource
 func mongodbAuth(info *core.HostInfo, parentCtx context.Context) error {
 	timeout := info.Timeout
 	if timeout == 0 {
 		timeout = 5 * time.Second
 	}
 
+	var lastErr error
+	for _, authSource := range mongodbAuthSources(info) {
+		// 检查context是否已取消
+		select {
+		case <-parentCtx.Done():
+			return parentCtx.Err()
+		default:
+		}
+
+		lastErr = tryMongodbAuth(info, parentCtx, authSource, timeout)
+		if lastErr == nil {
+			info.Report(&core.ScanResult{
+				Service:  "mongodb",
+				Username: info.Username,
+				Password: info.Password,
+				Success:  true,
+				VulnType: "weak_password",
+				Metadata: map[string]string{"authSource": authSource},
+			})
+			return nil
+		}
+	}
+
+	return lastErr
+}
+
+// tryMongodbAuth 使用指定的 authSource 尝试认证
+func tryMongodbAuth(info *core.HostInfo, parentCtx context.Context, authSource string, timeout time.Duration) error {
 	// 创建带超时的context用于单个请求
 	requestCtx, requestCancel := context.WithTimeout(parentCtx, timeout)
 	defer requestCancel()
 
-	// 检查context是否已取消
-	select {
-	case <-parentCtx.Done():
-		return parentCtx.Err()
-	default:
-	}
-
 	// 带认证的连接URI
-	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d/?connectTimeoutMS=%d&serverSelectionTimeoutMS=%d",
-		info.Username, info.Password, info.Host, info.Port,
-		int(timeout.Milliseconds()), int(timeout.Milliseconds()))
+	uri := mongodbURI(info, url.UserPassword(info.Username, info.Password), authSource, timeout)
 
 	clientOptions := options.Client().ApplyURI(uri)
 	clientOptions.SetConnectTimeout(timeout)
@@ -141,12 +248,64 @@ func mongodbAuth(info *core.HostInfo, parentCtx context.Context) error {
 	default:
 	}
 
-	err = client.Ping(requestCtx, nil)
-	if err == nil {
-		fmt.Printf("[+] %s:%d mongodb %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+	return client.Ping(requestCtx, nil)
+}
+
+// mongodbAuthSources 返回需要尝试的 authSource 列表
+// 选项：authsource=admin,test,$external  db=目标库（追加到列表末尾）
+func mongodbAuthSources(info *core.HostInfo) []string {
+	sources := info.OptionList("authsource", []string{"admin"})
+	if db := info.Option("db", ""); db != "" {
+		sources = append(sources, db)
+	}
+
+	// 去重，保持顺序
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if !seen[source] {
+			seen[source] = true
+			unique = append(unique, source)
+		}
 	}
+	return unique
+}
+
+// mongodbURI 构建连接URI，用户名和密码通过 url.Userinfo 转义
+// 选项：mechanism=SCRAM-SHA-1|SCRAM-SHA-256|PLAIN  tls=true  tls-insecure=true
+func mongodbURI(info *core.HostInfo, user *url.Userinfo, authSource string, timeout time.Duration) string {
+	query := url.Values{}
+	query.Set("connectTimeoutMS", strconv.FormatInt(timeout.Milliseconds(), 10))
+	query.Set("serverSelectionTimeoutMS", strconv.FormatInt(timeout.Milliseconds(), 10))
 
-	return err
+	if user != nil {
+		query.Set("authSource", authSource)
+
+		mechanism := strings.ToUpper(info.Option("mechanism", ""))
+		if mechanism == "" && authSource == "$external" {
+			// $external 上的用户名密码认证只能使用 PLAIN（LDAP）
+			mechanism = "PLAIN"
+		}
+		if mechanism != "" {
+			query.Set("authMechanism", mechanism)
+		}
+	}
+
+	if info.OptionBool("tls", false) || info.OptionBool("tls-insecure", false) {
+		query.Set("tls", "true")
+		if info.OptionBool("tls-insecure", false) {
+			query.Set("tlsInsecure", "true")
+		}
+	}
+
+	uri := url.URL{
+		Scheme:   "mongodb",
+		User:     user,
+		Host:     fmt.Sprintf("%s:%d", info.Host, info.Port),
+		Path:     "/",
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
 }
 
 // 注册插件
@@ -3,13 +3,28 @@ package plugins
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/denisenkom/go-mssqldb"
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/zan8in/leo/internal/core"
 )
 
+// MSSQL 错误码
+const (
+	mssqlErrCannotOpenDB    = 4060  // 无法打开指定数据库（凭据有效）
+	mssqlErrLoginFailed     = 18456 // 用户名或密码错误
+	mssqlErrAccountLocked   = 18486 // 账户已锁定
+	mssqlErrPasswordExpired = 18487 // 密码已过期（凭据有效）
+	mssqlErrMustChange      = 18488 // 密码必须修改（凭据有效）
+)
+
 // MssqlScan MSSQL扫描函数（参考fscan设计）
 func MssqlScan(info *core.HostInfo) error {
 	if info.Port == 0 {
@@ -41,14 +56,23 @@ func MssqlScan(info *core.HostInfo) error {
 	default:
 	}
 
-	// MSSQL连接字符串格式
-	dsn := fmt.Sprintf("server=%s;port=%d;user id=%s;password=%s;database=master;connection timeout=%d",
-		info.Host, info.Port, info.Username, info.Password, int(timeout.Seconds()))
+	// 命名实例使用动态端口，通过 SQL Browser 解析
+	instanceName := info.Option("instance", "")
+	if instanceName != "" {
+		instance, err := resolveMssqlInstance(ctx, info, instanceName, timeout)
+		if err != nil {
+			return err
+		}
+		info.Port = instance.Port
+	}
 
-	db, err := sql.Open("mssql", dsn)
+	username, auth := mssqlUsername(info)
+	connector, err := mssql.NewConnector(mssqlDSN(info, username, timeout))
 	if err != nil {
 		return err
 	}
+
+	db := sql.OpenDB(connector)
 	defer db.Close()
 
 	// 检查context是否已取消
@@ -59,13 +83,129 @@ func MssqlScan(info *core.HostInfo) error {
 	}
 
 	// 使用请求级context进行连接测试
-	err = db.PingContext(requestCtx)
+	note, err := classifyMssqlError(db.PingContext(requestCtx))
+	if err != nil {
+		return err
+	}
+
+	// 认证成功，输出结果
+	metadata := map[string]string{
+		"auth":    auth,
+		"encrypt": info.Option("encrypt", "false"),
+	}
+	if instanceName != "" {
+		metadata["instance"] = instanceName
+	}
+	if note != "" {
+		metadata["note"] = note
+	} else {
+		for key, value := range mssqlServerMetadata(requestCtx, db) {
+			metadata[key] = value
+		}
+	}
+	info.Report(&core.ScanResult{
+		Service:  "mssql",
+		Username: username,
+		Password: info.Password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: metadata,
+	})
+	return nil
+}
+
+// mssqlUsername 返回登录用户名及认证方式
+// 用户名含域名（DOMAIN\user、user@domain）或指定 -domain 时驱动使用 NTLM 认证域账户
+func mssqlUsername(info *core.HostInfo) (string, string) {
+	user, domain := ntlmAccount(info)
+	if domain != "" && user != "" {
+		return domain + `\` + user, "ntlm"
+	}
+	return info.Username, "sql"
+}
+
+// mssqlDSN 构建 URL 形式的连接字符串，凭据中的特殊字符由 net/url 转义
+// 选项：db=数据库（默认 master） encrypt=disable|false|true|strict trust-cert=true|false（默认 true）
+func mssqlDSN(info *core.HostInfo, username string, timeout time.Duration) string {
+	// 驱动只接受整秒，0 表示不超时：向上取整且至少 1 秒（默认 1.5s 取 2s）
+	seconds := strconv.Itoa(max(1, int(math.Ceil(timeout.Seconds()))))
+
+	query := url.Values{}
+	query.Set("database", info.Option("db", "master"))
+	query.Set("connection timeout", seconds)
+	query.Set("dial timeout", seconds)
+	if encrypt := info.Option("encrypt", ""); encrypt != "" {
+		query.Set("encrypt", encrypt)
+	}
+	// 扫描场景下目标多为自签名证书，默认不校验（strict 模式由驱动强制校验）
+	query.Set("TrustServerCertificate", strconv.FormatBool(info.OptionBool("trust-cert", true)))
+
+	dsn := &url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(username, info.Password),
+		Host:     net.JoinHostPort(info.Host, strconv.Itoa(info.Port)),
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// mssqlServerMetadata 登录后执行只读查询：版本、当前库、是否 sysadmin、xp_cmdshell 是否启用
+// 查询失败时返回已获取的部分信息
+func mssqlServerMetadata(ctx context.Context, db *sql.DB) map[string]string {
+	metadata := make(map[string]string)
+
+	var version, database string
+	var sysadmin sql.NullInt64
+	row := db.QueryRowContext(ctx, "SELECT @@VERSION, DB_NAME(), IS_SRVROLEMEMBER('sysadmin')")
+	if err := row.Scan(&version, &database, &sysadmin); err != nil {
+		return metadata
+	}
+
+	// @@VERSION 为多行文本，首行即产品及版本号
+	metadata["version"] = strings.TrimSpace(strings.SplitN(version, "\n", 2)[0])
+	metadata["database"] = database
+	metadata["sysadmin"] = strconv.FormatBool(sysadmin.Valid && sysadmin.Int64 == 1)
+	if sysadmin.Valid && sysadmin.Int64 == 1 {
+		// sysadmin 等同于 sa，可执行系统命令
+		metadata["severity"] = "high"
+	}
+
+	var cmdshell sql.NullInt64
+	row = db.QueryRowContext(ctx, "SELECT CONVERT(int, value_in_use) FROM sys.configurations WHERE name = 'xp_cmdshell'")
+	if err := row.Scan(&cmdshell); err == nil && cmdshell.Valid {
+		metadata["xp_cmdshell"] = "disabled"
+		if cmdshell.Int64 == 1 {
+			metadata["xp_cmdshell"] = "enabled"
+		}
+	}
+
+	return metadata
+}
+
+// classifyMssqlError 根据错误码对认证结果分类
+// 凭据有效但无法正常使用（无库权限、密码过期）时返回成功及说明
+func classifyMssqlError(err error) (string, error) {
 	if err == nil {
-		// 认证成功，输出结果
-		fmt.Printf("[+] %s:%d mssql %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+		return "", nil
+	}
+
+	var mssqlErr mssql.Error
+	if !errors.As(err, &mssqlErr) {
+		return "", err
+	}
+
+	switch mssqlErr.Number {
+	case mssqlErrCannotOpenDB:
+		return "cannot open database", nil
+	case mssqlErrPasswordExpired, mssqlErrMustChange:
+		return "password expired", nil
+	case mssqlErrLoginFailed:
+		return "", fmt.Errorf("mssql login failed: %s", mssqlErr.Message)
+	case mssqlErrAccountLocked:
+		return "", fmt.Errorf("%w: %s", core.ErrAccountLocked, mssqlErr.Message)
 	}
 
-	return err
+	return "", err
 }
 
 // 注册插件
@@ -0,0 +1,127 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// SQL Server Browser 协议（UDP 1434）
+const (
+	mssqlBrowserPort     = 1434
+	mssqlBrowserRequest  = 0x03 // CLNT_UCAST_EX：列出全部实例
+	mssqlBrowserResponse = 0x05 // SVR_RESP
+)
+
+// mssqlInstance SQL Browser 返回的实例信息
+type mssqlInstance struct {
+	Name    string
+	Version string
+	Port    int // TCP 端口，未启用 TCP 时为 0
+}
+
+// mssqlBrowserAttempts 单次解析中 SQL Browser 请求的最大次数（UDP 报文可能丢失）
+const mssqlBrowserAttempts = 2
+
+// mssqlBrowserResult 单个主机的 SQL Browser 查询结果
+type mssqlBrowserResult struct {
+	mu        sync.Mutex
+	instances []mssqlInstance
+	resolved  bool
+}
+
+// resolveMssqlInstance 通过 SQL Browser 解析命名实例的 TCP 端口
+// 查询结果在一次扫描内按主机缓存；只缓存成功的响应，失败时后续解析重新查询
+func resolveMssqlInstance(ctx context.Context, info *core.HostInfo, name string, timeout time.Duration) (*mssqlInstance, error) {
+	host := info.Host
+	value, _ := info.Cache("mssql.browser").LoadOrStore(host, &mssqlBrowserResult{})
+	result := value.(*mssqlBrowserResult)
+
+	result.mu.Lock()
+	if !result.resolved {
+		var err error
+		for attempt := 0; attempt < mssqlBrowserAttempts; attempt++ {
+			if result.instances, err = queryMssqlBrowser(ctx, host, timeout); err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		if err != nil {
+			result.mu.Unlock()
+			return nil, fmt.Errorf("sql browser query failed: %v", err)
+		}
+		result.resolved = true
+	}
+	result.mu.Unlock()
+
+	var names []string
+	for i, instance := range result.instances {
+		if strings.EqualFold(instance.Name, name) {
+			if instance.Port == 0 {
+				return nil, fmt.Errorf("instance %s has no tcp listener", instance.Name)
+			}
+			return &result.instances[i], nil
+		}
+		names = append(names, instance.Name)
+	}
+	return nil, fmt.Errorf("instance %s not found (available: %s)", name, strings.Join(names, ","))
+}
+
+// queryMssqlBrowser 向 SQL Browser 请求实例列表
+func queryMssqlBrowser(ctx context.Context, host string, timeout time.Duration) ([]mssqlInstance, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(mssqlBrowserPort)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte{mssqlBrowserRequest}); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseMssqlBrowser(buf[:n])
+}
+
+// parseMssqlBrowser 解析 SVR_RESP 响应
+// 格式：0x05 + 2字节长度 + "ServerName;X;InstanceName;Y;IsClustered;No;Version;Z;tcp;1433;;" 重复
+func parseMssqlBrowser(data []byte) ([]mssqlInstance, error) {
+	if len(data) < 3 || data[0] != mssqlBrowserResponse {
+		return nil, fmt.Errorf("invalid sql browser response")
+	}
+
+	var instances []mssqlInstance
+	for _, record := range strings.Split(string(data[3:]), ";;") {
+		fields := strings.Split(record, ";")
+		if len(fields) < 2 {
+			continue
+		}
+
+		values := make(map[string]string)
+		for i := 0; i+1 < len(fields); i += 2 {
+			values[strings.ToLower(fields[i])] = fields[i+1]
+		}
+		if values["instancename"] == "" {
+			continue
+		}
+
+		port, _ := strconv.Atoi(values["tcp"])
+		instances = append(instances, mssqlInstance{
+			Name:    values["instancename"],
+			Version: values["version"],
+			Port:    port,
+		})
+	}
+	return instances, nil
+}
@@ -0,0 +1,31 @@
+package plugins
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+func TestMssqlDSNTimeout(t *testing.T) {
+	tests := map[time.Duration]string{
+		1500 * time.Millisecond: "2",
+		500 * time.Millisecond:  "1",
+		time.Millisecond:        "1",
+		3 * time.Second:         "3",
+		0:                       "1",
+	}
+	info := &core.HostInfo{Host: "10.0.0.1", Port: 1433, Password: "p@ss"}
+	for timeout, want := range tests {
+		dsn, err := url.Parse(mssqlDSN(info, "sa", timeout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := dsn.Query()
+		if query.Get("connection timeout") != want || query.Get("dial timeout") != want {
+			t.Errorf("timeout %s: connection timeout %q, dial timeout %q, want %s",
+				timeout, query.Get("connection timeout"), query.Get("dial timeout"), want)
+		}
+	}
+}
@@ -5,20 +5,30 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/zan8in/leo/internal/core"
 )
 
-// MysqlScan MySQL扫描函数（参考fscan设计）
+// MySQL 错误码
+const (
+	mysqlErrDBAccessDenied  = 1044 // 无权访问指定数据库（凭据有效）
+	mysqlErrAccessDenied    = 1045 // 用户名或密码错误
+	mysqlErrHostBlocked     = 1129 // 连接错误过多，主机被封禁
+	mysqlErrHostNotAllowed  = 1130 // 主机不允许连接
+	mysqlErrPasswordExpired = 1820 // 密码已过期（凭据有效）
+	mysqlErrPasswordExpire2 = 1862 // 密码已过期，需修改后登录（凭据有效）
+)
+
+// MysqlScan MySQL扫描函数（参考fscan设计），同时用于MySQL协议家族
 func MysqlScan(info *core.HostInfo) error {
 	if info.Port == 0 {
 		info.Port = 3306 // MySQL默认端口
-	}
-
-	if info.Username == "" && info.Password == "" {
-		return errors.New("mysql username and password are empty")
+		if flavour := lookupMysqlFlavour(info.Service); flavour != nil {
+			info.Port = flavour.Port
+		}
 	}
 
 	// 获取context，如果没有则创建默认的
@@ -35,10 +45,6 @@ func MysqlScan(info *core.HostInfo) error {
 		timeout = 5 * time.Second
 	}
 
-	// 创建带超时的context用于单个请求
-	requestCtx, requestCancel := context.WithTimeout(ctx, timeout)
-	defer requestCancel()
-
 	// 检查context是否已取消
 	select {
 	case <-ctx.Done():
@@ -46,33 +52,136 @@ func MysqlScan(info *core.HostInfo) error {
 	default:
 	}
 
-	fmt.Println(info.Username, info.Password)
+	// 根据握手包识别产品类型
+	detectCtx, detectCancel := context.WithTimeout(ctx, timeout)
+	server, err := detectMysqlFlavour(detectCtx, info, timeout)
+	detectCancel()
+	if err != nil {
+		return err
+	}
+
+	// 空凭据阶段：先尝试匿名账户，再尝试产品默认账户
+	if info.Username == "" && info.Password == "" {
+		credentials := append([][2]string{{"", ""}}, server.Flavour.Credentials...)
+		found := false
+		for _, cred := range credentials {
+			if err := mysqlTry(ctx, info, server, cred[0], cred[1], timeout); err == nil {
+				found = true
+			} else if errors.Is(err, core.ErrTargetBlocked) {
+				return err
+			}
+		}
+		if found {
+			return nil
+		}
+		return fmt.Errorf("%s default credentials rejected", server.Flavour.Name)
+	}
+
+	return mysqlTry(ctx, info, server, info.Username, info.Password, timeout)
+}
 
-	// MySQL连接字符串格式
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/mysql?charset=utf8&timeout=%s&readTimeout=%s&writeTimeout=%s",
-		info.Username, info.Password, info.Host, info.Port, timeout, timeout, timeout)
+// mysqlTry 尝试一组凭据，成功时以识别出的产品名称上报结果；每次尝试使用独立的请求超时
+func mysqlTry(parentCtx context.Context, info *core.HostInfo, server *mysqlServerInfo, username, password string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(parentCtx, timeout)
+	defer cancel()
 
-	db, err := sql.Open("mysql", dsn)
+	cfg := mysqlConfig(info, timeout)
+	cfg.User = username
+	cfg.Passwd = password
+
+	// OceanBase 租户：user@tenant
+	if tenant := info.Option("tenant", ""); tenant != "" && server.Flavour.Name == "oceanbase" && !strings.Contains(username, "@") {
+		cfg.User = username + "@" + tenant
+	}
+
+	note, err := mysqlAuth(ctx, cfg)
 	if err != nil {
 		return err
 	}
+
+	// 认证成功，输出结果（空用户名表示匿名账户）
+	result := &core.ScanResult{
+		Service:  server.Flavour.Name,
+		Username: cfg.User,
+		Password: password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: map[string]string{"version": server.Version},
+	}
+	if username == "" {
+		result.VulnType = "unauth"
+	}
+	if note != "" {
+		result.Metadata["note"] = note
+	}
+	info.Report(result)
+	return nil
+}
+
+// mysqlConfig 根据插件选项构建连接配置（不含凭据）
+// 选项：db=默认库（默认不指定） tls=true|skip-verify|preferred allow-cleartext allow-native allow-old
+func mysqlConfig(info *core.HostInfo, timeout time.Duration) *mysql.Config {
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = fmt.Sprintf("%s:%d", info.Host, info.Port)
+	cfg.DBName = info.Option("db", "")
+	cfg.Timeout = timeout
+	cfg.ReadTimeout = timeout
+	cfg.WriteTimeout = timeout
+	cfg.Logger = &mysql.NopLogger{}
+	cfg.TLSConfig = info.Option("tls", "")
+	cfg.AllowCleartextPasswords = info.OptionBool("allow-cleartext", false)
+	cfg.AllowNativePasswords = info.OptionBool("allow-native", true)
+	cfg.AllowOldPasswords = info.OptionBool("allow-old", false)
+	return cfg
+}
+
+// mysqlAuth 使用给定配置尝试认证
+// 凭据有效但无法正常使用（无库权限、密码过期）时返回成功及说明
+func mysqlAuth(ctx context.Context, cfg *mysql.Config) (string, error) {
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	db := sql.OpenDB(connector)
 	defer db.Close()
 
 	// 检查context是否已取消
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return "", ctx.Err()
 	default:
 	}
 
 	// 使用请求级context进行连接测试
-	err = db.PingContext(requestCtx)
+	err = db.PingContext(ctx)
 	if err == nil {
-		// 认证成功，输出结果
-		fmt.Printf("[+] %s:%d mysql %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+		return "", nil
+	}
+
+	return classifyMysqlError(cfg.DBName, err)
+}
+
+// classifyMysqlError 根据错误码对认证结果分类
+func classifyMysqlError(dbName string, err error) (string, error) {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return "", err
+	}
+
+	switch mysqlErr.Number {
+	case mysqlErrDBAccessDenied:
+		return fmt.Sprintf("no access to database %s", dbName), nil
+	case mysqlErrPasswordExpired, mysqlErrPasswordExpire2:
+		return "password expired", nil
+	case mysqlErrAccessDenied:
+		return "", fmt.Errorf("mysql access denied: %s", mysqlErr.Message)
+	case mysqlErrHostBlocked, mysqlErrHostNotAllowed:
+		return "", fmt.Errorf("%w: mysql error %d: %s", core.ErrTargetBlocked, mysqlErr.Number, mysqlErr.Message)
 	}
 
-	return err
+	return "", err
 }
 
 // 注册插件
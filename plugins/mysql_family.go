@@ -0,0 +1,158 @@
+package plugins
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zan8in/leo/internal/core"
+)
+
+// mysqlFlavour MySQL协议兼容数据库的产品特征
+type mysqlFlavour struct {
+	Name        string      // 产品名称，作为结果中的服务名
+	Port        int         // 默认端口
+	Credentials [][2]string // 产品默认账户（用户名、密码）
+	match       func(version string) bool
+}
+
+// mysqlFlavours MySQL协议家族，按匹配优先级排列，最后一项为原生MySQL
+var mysqlFlavours = []mysqlFlavour{
+	{
+		Name:        "tidb",
+		Port:        4000,
+		Credentials: [][2]string{{"root", ""}},
+		match:       func(v string) bool { return strings.Contains(v, "TiDB") },
+	},
+	{
+		Name:        "oceanbase",
+		Port:        2881,
+		Credentials: [][2]string{{"root@sys", ""}, {"root", ""}},
+		match:       func(v string) bool { return strings.Contains(v, "OceanBase") },
+	},
+	{
+		Name:        "mariadb",
+		Port:        3306,
+		Credentials: [][2]string{{"root", ""}},
+		match:       func(v string) bool { return strings.Contains(v, "MariaDB") },
+	},
+	{
+		// Doris FE 默认上报的版本号固定为 5.7.99
+		Name:        "doris",
+		Port:        9030,
+		Credentials: [][2]string{{"root", ""}, {"admin", ""}},
+		match:       func(v string) bool { return strings.Contains(v, "Doris") || v == "5.7.99" },
+	},
+	{
+		// StarRocks FE 默认上报的版本号固定为 5.1.0
+		Name:        "starrocks",
+		Port:        9030,
+		Credentials: [][2]string{{"root", ""}},
+		match:       func(v string) bool { return strings.Contains(v, "StarRocks") || v == "5.1.0" },
+	},
+	{
+		Name:        "mysql",
+		Port:        3306,
+		Credentials: nil,
+		match:       func(v string) bool { return true },
+	},
+}
+
+// mysqlServerInfo 握手包中的服务端信息
+type mysqlServerInfo struct {
+	Version string
+	Flavour *mysqlFlavour
+}
+
+// lookupMysqlFlavour 按服务名查找产品特征
+func lookupMysqlFlavour(name string) *mysqlFlavour {
+	for i := range mysqlFlavours {
+		if mysqlFlavours[i].Name == name {
+			return &mysqlFlavours[i]
+		}
+	}
+	return nil
+}
+
+// detectMysqlFlavour 读取握手包中的服务端版本并识别产品类型，结果在一次扫描内按 host:port 缓存
+func detectMysqlFlavour(ctx context.Context, info *core.HostInfo, timeout time.Duration) (*mysqlServerInfo, error) {
+	key := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	detected := info.Cache("mysql")
+	if cached, ok := detected.Load(key); ok {
+		return cached.(*mysqlServerInfo), nil
+	}
+
+	version, err := readMysqlHandshake(ctx, key, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &mysqlServerInfo{Version: version}
+	for i := range mysqlFlavours {
+		if mysqlFlavours[i].match(version) {
+			server.Flavour = &mysqlFlavours[i]
+			break
+		}
+	}
+
+	detected.Store(key, server)
+	return server, nil
+}
+
+// readMysqlHandshake 读取初始握手包，返回服务端版本字符串
+func readMysqlHandshake(ctx context.Context, addr string, timeout time.Duration) (string, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// 包头：3字节长度 + 1字节序号
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("failed to read handshake header: %v", err)
+	}
+
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length == 0 || length > 1<<16 {
+		return "", fmt.Errorf("invalid handshake length: %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return "", fmt.Errorf("failed to read handshake: %v", err)
+	}
+
+	// 服务端在握手阶段直接返回错误包（如 1129/1130）
+	if payload[0] == 0xFF && len(payload) >= 3 {
+		code := binary.LittleEndian.Uint16(payload[1:3])
+		_, err := classifyMysqlError("", &mysql.MySQLError{Number: code, Message: string(payload[3:])})
+		return "", err
+	}
+
+	if payload[0] != 10 {
+		return "", fmt.Errorf("unsupported protocol version: %d", payload[0])
+	}
+
+	end := 1
+	for end < len(payload) && payload[end] != 0 {
+		end++
+	}
+	return string(payload[1:end]), nil
+}
+
+// 注册MySQL协议家族插件
+func init() {
+	for _, flavour := range mysqlFlavours {
+		if flavour.Name != "mysql" {
+			core.GlobalRegistry.Register(flavour.Name, MysqlScan)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package plugins
+
+import (
+	"github.com/zan8in/leo/internal/core"
+	"github.com/zan8in/leo/internal/ntlm"
+)
+
+// ntlmAccount 返回 NTLM 认证使用的用户名和域名
+// 用户名中的 DOMAIN\user 或 user@domain 优先，其次为 -domain 参数和 domain 选项
+func ntlmAccount(info *core.HostInfo) (string, string) {
+	user, domain := ntlm.ParseUser(info.Username)
+	if domain == "" {
+		domain = info.Domain
+	}
+	if domain == "" {
+		domain = info.Option("domain", "")
+	}
+	return user, domain
+}
@@ -3,13 +3,39 @@ package plugins
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	go_ora "github.com/sijms/go-ora/v2"
+	"github.com/sijms/go-ora/v2/network"
 	"github.com/zan8in/leo/internal/core"
 )
 
+// Oracle 错误码
+const (
+	oraErrInvalidLogon       = 1017  // 用户名或密码错误
+	oraErrNoCreateSession    = 1045  // 缺少 CREATE SESSION 权限（凭据有效）
+	oraErrUnknownSID         = 12505 // 监听器未识别的SID
+	oraErrUnknownService     = 12514 // 监听器未识别的服务名
+	oraErrAccountLocked      = 28000 // 账户已锁定
+	oraErrPasswordExpired    = 28001 // 密码已过期（凭据有效）
+	oraErrPasswordWillExpire = 28002 // 密码即将过期（凭据有效）
+	oraErrSysNeedsPrivilege  = 28009 // SYS 必须以 SYSDBA/SYSOPER 登录
+)
+
+// oracleAdminPrivileges 默认测试的管理权限
+var oracleAdminPrivileges = []string{"SYSDBA", "SYSOPER", "SYSBACKUP", "SYSDG", "SYSKM"}
+
+// errOracleUnknownService 服务名或SID不被监听器识别
+var errOracleUnknownService = errors.New("oracle unknown service")
+
+var oraCodeRegexp = regexp.MustCompile(`ORA-(\d{5})`)
+
 // OracleScan Oracle数据库扫描函数
 func OracleScan(info *core.HostInfo) error {
 	if info.Port == 0 {
@@ -41,106 +67,149 @@ func OracleScan(info *core.HostInfo) error {
 	default:
 	}
 
+	// 探测监听器（每个目标一次），使用目标级context避免被单次请求的超时打断
+	target := probeOracleTarget(ctx, info, timeout)
+
 	// 尝试Oracle认证
-	return oracleAuth(requestCtx, info)
+	return oracleAuth(requestCtx, info, target)
 }
 
-// oracleAuth Oracle认证函数
-func oracleAuth(ctx context.Context, info *core.HostInfo) error {
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
-	// 尝试多种连接方式，参考fscan的实现
-	serviceNames := []string{"XE", "ORCL", "xe", "orcl", "XEPDB1", "ORCLPDB1"}
-
-	// 首先尝试使用SERVICE_NAME连接
-	for _, serviceName := range serviceNames {
+// oracleAuth Oracle认证函数，服务名被监听器拒绝时切换到下一个已发现的服务
+func oracleAuth(ctx context.Context, info *core.HostInfo, target *oracleTarget) error {
+	var lastErr error
+	for _, service := range target.services() {
+		// 检查context是否已取消
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
 
-		if err := tryOracleConnect(ctx, info, serviceName, false); err == nil {
-			fmt.Printf("[+] %s:%d oracle %s:%s\n", info.Host, info.Port, info.Username, info.Password)
-			return nil
+		err := oracleLogin(ctx, info, target, service)
+		if !errors.Is(err, errOracleUnknownService) {
+			return err
 		}
+
+		// 服务名或SID错误与凭据无关，移除该服务后换下一个
+		target.dropService(service)
+		lastErr = err
 	}
 
-	// 尝试使用SID连接
-	for _, sid := range []string{"XE", "ORCL", "xe", "orcl"} {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	return fmt.Errorf("oracle auth failed: %v", lastErr)
+}
 
-		if err := tryOracleConnectWithSID(ctx, info, sid); err == nil {
-			fmt.Printf("[+] %s:%d oracle %s:%s\n", info.Host, info.Port, info.Username, info.Password)
-			return nil
-		}
+// oracleLogin 在指定服务上尝试凭据，凭据有效时继续测试管理权限
+func oracleLogin(ctx context.Context, info *core.HostInfo, target *oracleTarget, service oracleService) error {
+	metadata := map[string]string{
+		"service": service.String(),
+		"source":  target.Source,
+	}
+	if target.Version != "" {
+		metadata["listener_version"] = target.Version
 	}
 
-	// 尝试作为SYSDBA连接（如果用户名是sys）
-	if info.Username == "sys" || info.Username == "SYS" {
-		for _, serviceName := range serviceNames {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+	err := tryOracleConnect(ctx, info, service, "")
+	note, err := classifyOracleError(err)
 
-			if err := tryOracleConnect(ctx, info, serviceName, true); err == nil {
-				fmt.Printf("[+] %s:%d oracle %s:%s (SYSDBA)\n", info.Host, info.Port, info.Username, info.Password)
-				return nil
-			}
-		}
+	// SYS 只能以管理权限登录（ORA-28009），此时直接测试管理权限
+	sysOnly := err != nil && oracleErrorCode(err) == oraErrSysNeedsPrivilege
+	if err != nil && !sysOnly {
+		return err
 	}
 
-	return fmt.Errorf("oracle auth failed: all connection attempts failed")
-}
+	granted := oracleTestPrivileges(ctx, info, service)
+	if sysOnly && len(granted) == 0 {
+		return err
+	}
 
-// tryOracleConnect 尝试使用SERVICE_NAME连接
-func tryOracleConnect(ctx context.Context, info *core.HostInfo, serviceName string, asSysdba bool) error {
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+	if len(granted) > 0 {
+		metadata["privilege"] = strings.Join(granted, ",")
+		// 非 SYS 账户被授予 SYSDBA 属于高危配置
+		if slices.Contains(granted, "SYSDBA") && !strings.EqualFold(info.Username, "sys") {
+			metadata["severity"] = "high"
+		}
+	}
+	if note != "" && !sysOnly {
+		metadata["note"] = note
 	}
+	info.Report(&core.ScanResult{
+		Service:  "oracle",
+		Username: info.Username,
+		Password: info.Password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: metadata,
+	})
+	return nil
+}
 
-	// 设置连接超时
-	timeout := info.Timeout
-	if timeout == 0 {
-		timeout = 5 * time.Second
+// oracleTestPrivileges 逐个测试管理权限登录，返回凭据拥有的权限
+// 选项：privileges=逗号分隔的权限列表（默认全部，none 表示不测试）
+func oracleTestPrivileges(ctx context.Context, info *core.HostInfo, service oracleService) []string {
+	var granted []string
+	for _, privilege := range info.OptionList("privileges", oracleAdminPrivileges) {
+		privilege = strings.ToUpper(privilege)
+		if privilege == "NONE" {
+			return nil
+		}
+
+		// 检查context是否已取消
+		select {
+		case <-ctx.Done():
+			return granted
+		default:
+		}
+
+		// 只有真正登录成功才算拥有该权限；密码过期等"凭据有效"的错误不能证明权限
+		if err := tryOracleConnect(ctx, info, service, privilege); err == nil {
+			granted = append(granted, privilege)
+		}
 	}
+	return granted
+}
 
-	urlOptions := map[string]string{
-		"CONNECTION TIMEOUT": fmt.Sprintf("%.0f", timeout.Seconds()),
+// oracleErrorCode 提取 ORA 错误码，无法识别时返回 0
+func oracleErrorCode(err error) int {
+	var oraErr *network.OracleError
+	if errors.As(err, &oraErr) {
+		return oraErr.ErrCode
 	}
 
-	if asSysdba {
-		urlOptions["SYSDBA"] = "true"
+	// 监听器拒绝等错误只以文本形式返回
+	if match := oraCodeRegexp.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		return code
 	}
+	return 0
+}
 
-	connStr := go_ora.BuildUrl(info.Host, info.Port, serviceName, info.Username, info.Password, urlOptions)
+// classifyOracleError 根据 ORA 错误码对认证结果分类
+// 凭据有效但无法正常使用（密码过期、无 CREATE SESSION 权限）时返回成功及说明
+func classifyOracleError(err error) (string, error) {
+	if err == nil {
+		return "", nil
+	}
 
-	db, err := sql.Open("oracle", connStr)
-	if err != nil {
-		return err
+	switch oracleErrorCode(err) {
+	case oraErrPasswordExpired:
+		return "password expired", nil
+	case oraErrPasswordWillExpire:
+		return "password will expire soon", nil
+	case oraErrNoCreateSession:
+		return "no CREATE SESSION privilege", nil
+	case oraErrInvalidLogon:
+		return "", fmt.Errorf("oracle invalid username/password: %v", err)
+	case oraErrAccountLocked:
+		return "", fmt.Errorf("%w: %v", core.ErrAccountLocked, err)
+	case oraErrUnknownSID, oraErrUnknownService:
+		return "", fmt.Errorf("%w: %v", errOracleUnknownService, err)
 	}
-	defer db.Close()
 
-	// 使用传入的context进行连接测试
-	return db.PingContext(ctx)
+	return "", err
 }
 
-// tryOracleConnectWithSID 尝试使用SID连接
-func tryOracleConnectWithSID(ctx context.Context, info *core.HostInfo, sid string) error {
+// tryOracleConnect 使用服务名或SID尝试连接，privilege 为空时以普通用户登录
+func tryOracleConnect(ctx context.Context, info *core.HostInfo, service oracleService, privilege string) error {
 	// 检查context是否已取消
 	select {
 	case <-ctx.Done():
@@ -155,11 +224,22 @@ func tryOracleConnectWithSID(ctx context.Context, info *core.HostInfo, sid strin
 	}
 
 	urlOptions := map[string]string{
-		"SID":                sid,
 		"CONNECTION TIMEOUT": fmt.Sprintf("%.0f", timeout.Seconds()),
 	}
 
-	connStr := go_ora.BuildUrl(info.Host, info.Port, "", info.Username, info.Password, urlOptions)
+	// go-ora 对 SYS 用户默认使用 SYSDBA，这里显式指定以区分普通登录和管理权限登录
+	if privilege == "" {
+		privilege = "NORMAL"
+	}
+	urlOptions["DBA PRIVILEGE"] = privilege
+
+	serviceName := service.Name
+	if service.IsSID {
+		urlOptions["SID"] = service.Name
+		serviceName = ""
+	}
+
+	connStr := go_ora.BuildUrl(info.Host, info.Port, serviceName, info.Username, info.Password, urlOptions)
 
 	db, err := sql.Open("oracle", connStr)
 	if err != nil {
@@ -0,0 +1,343 @@
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// TNS 包类型
+const (
+	tnsPacketConnect  = 1
+	tnsPacketAccept   = 2
+	tnsPacketRefuse   = 4
+	tnsPacketRedirect = 5
+	tnsPacketResend   = 11
+
+	tnsConnectDataOffset = 58
+)
+
+// tnsServiceStateErrors 表示服务已注册、但当前无法分配处理程序的 REFUSE 错误码；
+// 12505/12514（未识别的 SID/服务名）以及 12504、1153 等格式或参数错误对任何猜测都会返回，不能说明服务存在
+var tnsServiceStateErrors = map[string]bool{
+	"12516": true, // 没有协议栈匹配的可用处理程序
+	"12518": true, // 无法移交客户端连接
+	"12519": true, // 没有合适的服务处理程序
+	"12520": true, // 没有所需服务器类型的可用处理程序
+	"12528": true, // 所有合适的实例都在阻止新连接
+}
+
+// oracleDefaultSIDs 内置的 SID / 服务名字典
+var oracleDefaultSIDs = []string{
+	"XE", "ORCL", "ORCLCDB", "ORCLPDB1", "XEPDB1", "PROD", "PRD", "DEV", "TEST",
+	"ORA", "ORACLE", "DB", "DB01", "DB11G", "ORCL11G", "ORCL12C", "ORCL19C",
+	"CDB1", "PDB1", "EBS", "SAP", "PLSEXTPROC", "IASDB", "OEMREP", "RMAN",
+}
+
+var (
+	tnsServiceRegexp  = regexp.MustCompile(`(?i)SERVICE_NAME=([^)\s]+)`)
+	tnsInstanceRegexp = regexp.MustCompile(`(?i)INSTANCE_NAME=([^)\s]+)`)
+	tnsVersionRegexp  = regexp.MustCompile(`Version ([0-9][0-9.]+)`)
+	tnsVsnnumRegexp   = regexp.MustCompile(`VSNNUM=([0-9]+)`)
+	tnsErrRegexp      = regexp.MustCompile(`ERR=([0-9]+)`)
+)
+
+// oracleService 可用于连接的服务名或SID
+type oracleService struct {
+	Name  string
+	IsSID bool
+}
+
+func (s oracleService) String() string {
+	if s.IsSID {
+		return "SID=" + s.Name
+	}
+	return "SERVICE_NAME=" + s.Name
+}
+
+// oracleTarget 单个目标的监听器探测结果
+type oracleTarget struct {
+	once     sync.Once
+	mu       sync.Mutex
+	Version  string
+	Source   string // 服务名来源：option、status、guess、default
+	Services []oracleService
+}
+
+// probeOracleTarget 探测监听器版本并确定服务名，结果在一次扫描内按 host:port 缓存，保证每个目标只探测一次
+func probeOracleTarget(ctx context.Context, info *core.HostInfo, timeout time.Duration) *oracleTarget {
+	key := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	value, _ := info.Cache("oracle").LoadOrStore(key, &oracleTarget{})
+	target := value.(*oracleTarget)
+
+	target.once.Do(func() {
+		target.discover(ctx, info, timeout)
+	})
+	return target
+}
+
+// discover 依次通过选项、STATUS 命令、字典猜测确定服务名
+// 选项：service=服务名 sid=SID sidlist=字典文件
+func (t *oracleTarget) discover(ctx context.Context, info *core.HostInfo, timeout time.Duration) {
+	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
+
+	// 监听器版本
+	if text, err := tnsCommand(ctx, addr, "(CONNECT_DATA=(COMMAND=version))", timeout); err == nil {
+		t.Version = parseTNSVersion(text)
+	}
+
+	// 用户指定的服务名或SID
+	for _, name := range info.OptionList("service", nil) {
+		t.Services = append(t.Services, oracleService{Name: name})
+	}
+	for _, name := range info.OptionList("sid", nil) {
+		t.Services = append(t.Services, oracleService{Name: name, IsSID: true})
+	}
+	if len(t.Services) > 0 {
+		t.Source = "option"
+		return
+	}
+
+	// 旧版本监听器允许远程执行 STATUS 命令，直接列出服务
+	if text, err := tnsCommand(ctx, addr, "(CONNECT_DATA=(COMMAND=status))", timeout); err == nil {
+		for _, match := range tnsServiceRegexp.FindAllStringSubmatch(text, -1) {
+			t.addService(oracleService{Name: match[1]})
+		}
+		for _, match := range tnsInstanceRegexp.FindAllStringSubmatch(text, -1) {
+			t.addService(oracleService{Name: match[1], IsSID: true})
+		}
+		if len(t.Services) > 0 {
+			t.Source = "status"
+			return
+		}
+	}
+
+	// 使用字典猜测 SID 和服务名
+	for _, name := range oracleSIDWordlist(info) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		for _, service := range []oracleService{{Name: name, IsSID: true}, {Name: name}} {
+			if tnsServiceExists(ctx, addr, info.Host, info.Port, service, timeout) {
+				t.addService(service)
+				t.Source = "guess"
+				return
+			}
+		}
+	}
+
+	// 无法确定时退回到常见服务名
+	t.Source = "default"
+	t.Services = []oracleService{{Name: "XE"}, {Name: "ORCL"}}
+}
+
+// addService 添加服务（去重）
+func (t *oracleTarget) addService(service oracleService) {
+	for _, existing := range t.Services {
+		if strings.EqualFold(existing.Name, service.Name) && existing.IsSID == service.IsSID {
+			return
+		}
+	}
+	t.Services = append(t.Services, service)
+}
+
+// services 返回当前可用服务的副本
+func (t *oracleTarget) services() []oracleService {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]oracleService(nil), t.Services...)
+}
+
+// dropService 移除被监听器拒绝的服务，后续凭据不再尝试（至少保留一个）
+func (t *oracleTarget) dropService(service oracleService) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.Services) <= 1 {
+		return
+	}
+	for i, existing := range t.Services {
+		if existing == service {
+			t.Services = append(t.Services[:i:i], t.Services[i+1:]...)
+			return
+		}
+	}
+}
+
+// oracleSIDWordlist 读取SID字典，未指定时使用内置字典
+func oracleSIDWordlist(info *core.HostInfo) []string {
+	path := info.Option("sidlist", "")
+	if path == "" {
+		return oracleDefaultSIDs
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return oracleDefaultSIDs
+	}
+	defer file.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+	if len(names) == 0 {
+		return oracleDefaultSIDs
+	}
+	return names
+}
+
+// tnsServiceExists 发送连接请求，根据监听器的拒绝原因判断服务名或SID是否存在
+func tnsServiceExists(ctx context.Context, addr, host string, port int, service oracleService, timeout time.Duration) bool {
+	connectData := fmt.Sprintf("(DESCRIPTION=(CONNECT_DATA=(%s)(CID=(PROGRAM=leo)(HOST=leo)(USER=leo)))(ADDRESS=(PROTOCOL=TCP)(HOST=%s)(PORT=%d)))",
+		service, host, port)
+
+	packetType, data, err := tnsConnect(ctx, addr, connectData, timeout)
+	if err != nil {
+		return false
+	}
+	return tnsServiceAccepted(packetType, data)
+}
+
+// tnsServiceAccepted 根据监听器的响应判断服务是否存在：ACCEPT、REDIRECT 表示接受了该服务，
+// REFUSE 只有错误码表示服务处理程序状态时才说明服务已注册
+func tnsServiceAccepted(packetType byte, data string) bool {
+	switch packetType {
+	case tnsPacketAccept, tnsPacketRedirect:
+		return true
+	case tnsPacketRefuse:
+		return tnsServiceStateErrors[tnsRefuseError(data)]
+	}
+	return false
+}
+
+// tnsRefuseError 提取 REFUSE 响应中的错误码（ERR=），没有时返回空字符串
+func tnsRefuseError(data string) string {
+	if match := tnsErrRegexp.FindStringSubmatch(data); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// tnsCommand 向监听器发送命令，返回响应中的文本
+func tnsCommand(ctx context.Context, addr, command string, timeout time.Duration) (string, error) {
+	_, data, err := tnsConnect(ctx, addr, command, timeout)
+	return data, err
+}
+
+// tnsConnect 发送 CONNECT 包，返回首个非 RESEND 响应的类型及其后所有文本
+func tnsConnect(ctx context.Context, addr, connectData string, timeout time.Duration) (byte, string, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	packet := buildTNSConnect(connectData)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return 0, "", err
+		}
+
+		packetType, body, err := readTNSPacket(conn)
+		if err != nil {
+			return 0, "", err
+		}
+		if packetType == tnsPacketResend {
+			continue
+		}
+
+		// 部分响应（如 STATUS）在后续 DATA 包中继续输出，读到超时或连接关闭为止
+		var text bytes.Buffer
+		text.Write(body)
+		conn.SetReadDeadline(time.Now().Add(timeout / 2))
+		for {
+			_, more, err := readTNSPacket(conn)
+			if err != nil {
+				break
+			}
+			text.Write(more)
+		}
+		return packetType, text.String(), nil
+	}
+
+	return 0, "", fmt.Errorf("listener keeps requesting resend")
+}
+
+// buildTNSConnect 构建 TNS CONNECT 包
+func buildTNSConnect(connectData string) []byte {
+	packet := make([]byte, tnsConnectDataOffset, tnsConnectDataOffset+len(connectData))
+
+	// 包头
+	binary.BigEndian.PutUint16(packet[0:2], uint16(tnsConnectDataOffset+len(connectData)))
+	packet[4] = tnsPacketConnect
+
+	// 连接参数
+	binary.BigEndian.PutUint16(packet[8:10], 0x0136)  // 版本
+	binary.BigEndian.PutUint16(packet[10:12], 0x012C) // 兼容的最低版本
+	binary.BigEndian.PutUint16(packet[14:16], 0x0800) // SDU
+	binary.BigEndian.PutUint16(packet[16:18], 0x7FFF) // TDU
+	binary.BigEndian.PutUint16(packet[18:20], 0x7F08) // NT 协议特性
+	binary.BigEndian.PutUint16(packet[22:24], 0x0001) // 硬件字节序标识
+	binary.BigEndian.PutUint16(packet[24:26], uint16(len(connectData)))
+	binary.BigEndian.PutUint16(packet[26:28], tnsConnectDataOffset)
+
+	return append(packet, connectData...)
+}
+
+// readTNSPacket 读取一个 TNS 包，返回类型和包体
+func readTNSPacket(conn net.Conn) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[0:2]))
+	if length < 8 {
+		return 0, nil, fmt.Errorf("invalid TNS packet length: %d", length)
+	}
+
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return 0, nil, err
+	}
+	return header[4], body, nil
+}
+
+// parseTNSVersion 从 VERSION 命令的响应中解析监听器版本
+func parseTNSVersion(text string) string {
+	if match := tnsVersionRegexp.FindStringSubmatch(text); match != nil {
+		return match[1]
+	}
+
+	match := tnsVsnnumRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	vsnnum, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return ""
+	}
+
+	// VSNNUM 按十六进制分段编码，如 0x0B200200 -> 11.2.0.2.0
+	return fmt.Sprintf("%d.%d.%d.%d.%d",
+		vsnnum>>24, (vsnnum>>20)&0xF, (vsnnum>>12)&0xFF, (vsnnum>>8)&0xF, vsnnum&0xFF)
+}
@@ -0,0 +1,258 @@
+package plugins
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// 监听器响应文本，按 11g / 19c 监听器输出的格式构造
+const (
+	tnsVersion11g = "TNSLSNR for Linux: Version 11.2.0.4.0 - Production\n" +
+		"\tTNS for Linux: Version 11.2.0.4.0 - Production\n" +
+		"\tUnix Domain Socket IPC NT Protocol Adaptor for Linux: Version 11.2.0.4.0 - Production\n" +
+		"\tOracle Bequeath NT Protocol Adapter for Linux: Version 11.2.0.4.0 - Production\n" +
+		"\tTCP/IP NT Protocol Adapter for Linux: Version 11.2.0.4.0 - Production,,"
+	tnsStatus11g = "(DESCRIPTION=(TMP=)(VSNNUM=186647552)(ERR=0)(ALIAS=LISTENER)(SECURITY=OFF)(VERSION=TNSLSNR for Linux: Version 11.2.0.4.0 - Production)" +
+		"(START_DATE=01-JAN-2024 00:00:00)(SIDNUM=1)(LOGFILE=/u01/app/oracle/diag/tnslsnr/db/listener/alert/log.xml)" +
+		"(PRMFILE=/u01/app/oracle/product/11.2.0/dbhome_1/network/admin/listener.ora)(SERVICE=(SERVICE_NAME=PROD)(INSTANCE=(INSTANCE_NAME=PROD1)" +
+		"(NUM=1)(INSTANCE_STATUS=READY)))(SERVICE=(SERVICE_NAME=PRODXDB)(INSTANCE=(INSTANCE_NAME=PROD1)(NUM=1)(INSTANCE_STATUS=READY))))"
+)
+
+// tnsRefuseText 监听器拒绝连接时 REFUSE 包中的文本
+func tnsRefuseText(vsnnum, code string) string {
+	return "(DESCRIPTION=(TMP=)(VSNNUM=" + vsnnum + ")(ERR=" + code + ")(ERROR_STACK=(ERROR=(CODE=" + code + ")(EMFI=4))))"
+}
+
+// tnsPacket 构造 TNS 包
+func tnsPacket(packetType byte, body []byte) []byte {
+	packet := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint16(packet[0:2], uint16(8+len(body)))
+	packet[4] = packetType
+	return append(packet, body...)
+}
+
+// tnsRefusePacket REFUSE 包：用户原因、系统原因、文本长度和文本
+func tnsRefusePacket(text string) []byte {
+	body := []byte{0x22, 0x00}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(text)))
+	return tnsPacket(tnsPacketRefuse, append(body, text...))
+}
+
+// tnsAcceptPacket ACCEPT 包，版本 0x0136，不附带数据
+func tnsAcceptPacket() []byte {
+	body := make([]byte, 24)
+	binary.BigEndian.PutUint16(body[0:2], 0x0136)
+	binary.BigEndian.PutUint16(body[4:6], 0x0800)
+	binary.BigEndian.PutUint16(body[6:8], 0x7FFF)
+	return tnsPacket(tnsPacketAccept, body)
+}
+
+// tnsRedirectPacket REDIRECT 包：文本长度和新地址
+func tnsRedirectPacket(address string) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(address)))
+	return tnsPacket(tnsPacketRedirect, append(body, address...))
+}
+
+// tnsDataPacket DATA 包（类型 6）：2 字节数据标志和文本
+func tnsDataPacket(text string) []byte {
+	return tnsPacket(6, append([]byte{0, 0}, text...))
+}
+
+// fakeTNSListener 按 CONNECT 包中的连接数据返回预设响应的监听器
+func fakeTNSListener(t *testing.T, respond func(connectData string) [][]byte) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				for {
+					packetType, body, err := readTNSPacket(conn)
+					if err != nil || packetType != tnsPacketConnect {
+						return
+					}
+					offset := int(binary.BigEndian.Uint16(body[18:20])) - 8
+					length := int(binary.BigEndian.Uint16(body[16:18]))
+					packets := respond(string(body[offset : offset+length]))
+					for _, packet := range packets {
+						conn.Write(packet)
+					}
+					// RESEND 后等待客户端重发
+					if len(packets) != 1 || packets[0][4] != tnsPacketResend {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestBuildTNSConnect(t *testing.T) {
+	connectData := "(CONNECT_DATA=(COMMAND=version))"
+	packet := buildTNSConnect(connectData)
+
+	if len(packet) != tnsConnectDataOffset+len(connectData) {
+		t.Fatalf("len = %d", len(packet))
+	}
+	fields := []struct {
+		name   string
+		offset int
+		want   uint16
+	}{
+		{"packet length", 0, uint16(len(packet))},
+		{"version", 8, 0x0136},
+		{"lowest version", 10, 0x012C},
+		{"SDU", 14, 0x0800},
+		{"TDU", 16, 0x7FFF},
+		{"connect data length", 24, uint16(len(connectData))},
+		{"connect data offset", 26, tnsConnectDataOffset},
+	}
+	for _, field := range fields {
+		if got := binary.BigEndian.Uint16(packet[field.offset:]); got != field.want {
+			t.Errorf("%s = %#x, want %#x", field.name, got, field.want)
+		}
+	}
+	if packet[4] != tnsPacketConnect {
+		t.Errorf("packet type = %d", packet[4])
+	}
+	if string(packet[tnsConnectDataOffset:]) != connectData {
+		t.Errorf("connect data = %q", packet[tnsConnectDataOffset:])
+	}
+}
+
+func TestParseTNSVersion(t *testing.T) {
+	tests := map[string]string{
+		tnsVersion11g:                          "11.2.0.4.0",
+		tnsRefuseText("318767104", "1189"):     "19.0.0.0.0", // 19c 拒绝远程命令，只能从 VSNNUM 解析
+		tnsRefuseText("186647552", "1189"):     "11.2.0.4.0",
+		"(DESCRIPTION=(ERR=1153)(VSNNUM=abc))": "",
+	}
+	for text, want := range tests {
+		if got := parseTNSVersion(text); got != want {
+			t.Errorf("parseTNSVersion(%.40q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestTNSServiceAccepted(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   bool
+	}{
+		{"accept", tnsAcceptPacket(), true},
+		{"redirect", tnsRedirectPacket("(ADDRESS=(PROTOCOL=tcp)(HOST=10.0.0.5)(PORT=1522))"), true},
+		{"unknown SID", tnsRefusePacket(tnsRefuseText("318767104", "12505")), false},
+		{"unknown service", tnsRefusePacket(tnsRefuseText("318767104", "12514")), false},
+		{"no handler", tnsRefusePacket(tnsRefuseText("318767104", "12516")), true},
+		{"blocking", tnsRefusePacket(tnsRefuseText("318767104", "12528")), true},
+		{"SID not given", tnsRefusePacket(tnsRefuseText("318767104", "12504")), false},
+		{"incompatible version", tnsRefusePacket(tnsRefuseText("186647552", "1153")), false},
+		{"no error code", tnsRefusePacket("(DESCRIPTION=(TMP=))"), false},
+	}
+	for _, tt := range tests {
+		port := fakeTNSListener(t, func(string) [][]byte {
+			return [][]byte{tt.packet}
+		})
+		service := oracleService{Name: "ORCL", IsSID: true}
+		ctx := context.Background()
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+		if got := tnsServiceExists(ctx, addr, "127.0.0.1", port, service, time.Second); got != tt.want {
+			t.Errorf("%s: tnsServiceExists = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if code := tnsRefuseError(tnsRefuseText("318767104", "12514")); code != "12514" {
+		t.Errorf("tnsRefuseError = %q", code)
+	}
+}
+
+func TestTNSResend(t *testing.T) {
+	// 监听器先要求重发，再拒绝
+	resent := false
+	port := fakeTNSListener(t, func(string) [][]byte {
+		if !resent {
+			resent = true
+			return [][]byte{tnsPacket(tnsPacketResend, nil)}
+		}
+		return [][]byte{tnsRefusePacket(tnsRefuseText("318767104", "12514"))}
+	})
+	packetType, text, err := tnsConnect(context.Background(), fmt.Sprintf("127.0.0.1:%d", port), "(CONNECT_DATA=(SERVICE_NAME=X))", time.Second)
+	if err != nil || packetType != tnsPacketRefuse || tnsRefuseError(text) != "12514" {
+		t.Errorf("packet type %d, text %q, err %v", packetType, text, err)
+	}
+}
+
+// oracleDiscover 对本地监听器执行服务探测
+func oracleDiscover(t *testing.T, port int) *oracleTarget {
+	t.Helper()
+	info := &core.HostInfo{Host: "127.0.0.1", Port: port, Options: map[string]string{}}
+	target := &oracleTarget{}
+	target.discover(context.Background(), info, time.Second)
+	return target
+}
+
+func TestOracleDiscover(t *testing.T) {
+	// 11g：VERSION 与 STATUS 的结果在 ACCEPT 之后的 DATA 包中
+	port := fakeTNSListener(t, func(connectData string) [][]byte {
+		switch {
+		case strings.Contains(connectData, "COMMAND=version"):
+			return [][]byte{tnsAcceptPacket(), tnsDataPacket(tnsVersion11g)}
+		case strings.Contains(connectData, "COMMAND=status"):
+			return [][]byte{tnsAcceptPacket(), tnsDataPacket(tnsStatus11g)}
+		}
+		return [][]byte{tnsRefusePacket(tnsRefuseText("186647552", "12505"))}
+	})
+	target := oracleDiscover(t, port)
+	if target.Version != "11.2.0.4.0" || target.Source != "status" {
+		t.Errorf("version %q, source %q", target.Version, target.Source)
+	}
+	var services []string
+	for _, service := range target.Services {
+		services = append(services, service.String())
+	}
+	if got := strings.Join(services, ","); got != "SERVICE_NAME=PROD,SERVICE_NAME=PRODXDB,SID=PROD1" {
+		t.Errorf("services = %s", got)
+	}
+
+	// 19c：拒绝远程命令，按字典猜测；ORCLCDB 已注册但实例阻止新连接
+	port = fakeTNSListener(t, func(connectData string) [][]byte {
+		switch {
+		case strings.Contains(connectData, "COMMAND="):
+			return [][]byte{tnsRefusePacket(tnsRefuseText("318767104", "1189"))}
+		case strings.Contains(connectData, "(SERVICE_NAME=ORCLCDB)"):
+			return [][]byte{tnsRefusePacket(tnsRefuseText("318767104", "12528"))}
+		case strings.Contains(connectData, "(SID="):
+			return [][]byte{tnsRefusePacket(tnsRefuseText("318767104", "12505"))}
+		}
+		return [][]byte{tnsRefusePacket(tnsRefuseText("318767104", "12514"))}
+	})
+	target = oracleDiscover(t, port)
+	if target.Version != "19.0.0.0.0" || target.Source != "guess" || len(target.Services) != 1 || target.Services[0].String() != "SERVICE_NAME=ORCLCDB" {
+		t.Errorf("version %q, source %q, services %v", target.Version, target.Source, target.Services)
+	}
+
+	// 对任何猜测都返回格式错误的监听器不能确定服务，退回默认服务名
+	port = fakeTNSListener(t, func(string) [][]byte {
+		return [][]byte{tnsRefusePacket(tnsRefuseText("318767104", "12504"))}
+	})
+	if target = oracleDiscover(t, port); target.Source != "default" {
+		t.Errorf("source %q, services %v, want default", target.Source, target.Services)
+	}
+}
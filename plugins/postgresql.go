@@ -2,18 +2,53 @@ package plugins
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
 	"github.com/zan8in/leo/internal/core"
 )
 
-// PostgresqlScan PostgreSQL数据库扫描函数
+// PostgreSQL 错误码（SQLSTATE）
+const (
+	pgErrInvalidAuthSpec = "28000" // 无 pg_hba 规则或角色不存在
+	pgErrUnknownDatabase = "3D000" // 数据库不存在，该错误在认证通过后返回
+)
+
+// pgFlavour PostgreSQL协议兼容数据库的产品特征
+type pgFlavour struct {
+	Name        string   // 产品名称，作为结果中的服务名
+	Port        int      // 默认端口
+	DefaultUser string   // 空凭据阶段用于探测 trust 认证的用户
+	Databases   []string // 尝试连接的数据库
+	Keyword     string   // version() 输出中的产品关键字
+}
+
+// pgFlavours PostgreSQL协议家族，最后一项为原生PostgreSQL
+var pgFlavours = []pgFlavour{
+	{Name: "opengauss", Port: 5432, DefaultUser: "gaussdb", Databases: []string{"postgres"}, Keyword: "openGauss"},
+	{Name: "kingbase", Port: 54321, DefaultUser: "system", Databases: []string{"test", "template1", "security"}, Keyword: "KingbaseES"},
+	{Name: "greenplum", Port: 5432, DefaultUser: "gpadmin", Databases: []string{"postgres", "template1"}, Keyword: "Greenplum"},
+	{Name: "cockroachdb", Port: 26257, DefaultUser: "root", Databases: []string{"defaultdb", "system"}, Keyword: "CockroachDB"},
+	{Name: "postgresql", Port: 5432, DefaultUser: "postgres", Databases: []string{"postgres", "template1", "template0"}, Keyword: "PostgreSQL"},
+}
+
+// lookupPgFlavour 按服务名查找产品特征，未知服务按原生PostgreSQL处理
+func lookupPgFlavour(name string) *pgFlavour {
+	for i := range pgFlavours {
+		if pgFlavours[i].Name == name {
+			return &pgFlavours[i]
+		}
+	}
+	return &pgFlavours[len(pgFlavours)-1]
+}
+
+// PostgresqlScan PostgreSQL数据库扫描函数，同时用于PostgreSQL协议家族
 func PostgresqlScan(info *core.HostInfo) error {
+	flavour := lookupPgFlavour(info.Service)
 	if info.Port == 0 {
-		info.Port = 5432 // PostgreSQL默认端口
+		info.Port = flavour.Port
 	}
 
 	// 获取context，如果没有则创建默认的
@@ -42,27 +77,27 @@ func PostgresqlScan(info *core.HostInfo) error {
 	}
 
 	// 尝试PostgreSQL认证
-	return postgresqlAuth(requestCtx, info)
+	return postgresqlAuth(requestCtx, info, flavour)
 }
 
 // postgresqlAuth PostgreSQL认证函数
-func postgresqlAuth(ctx context.Context, info *core.HostInfo) error {
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
+func postgresqlAuth(ctx context.Context, info *core.HostInfo, flavour *pgFlavour) error {
 	// 设置连接超时
 	timeout := info.Timeout
 	if timeout == 0 {
 		timeout = 5 * time.Second
 	}
 
-	// 尝试连接不同的数据库
-	databases := []string{"postgres", "template1", "template0"}
+	// 空凭据阶段使用产品默认用户探测 trust 认证
+	username := info.Username
+	if username == "" && info.Password == "" {
+		username = flavour.DefaultUser
+	}
+
+	// 尝试连接不同的数据库（可通过 -o db= 指定），仅在 pg_hba 拒绝该库时切换下一个
+	databases := info.OptionList("db", flavour.Databases)
 
+	var lastErr error
 	for _, dbname := range databases {
 		// 检查context是否已取消
 		select {
@@ -71,39 +106,174 @@ func postgresqlAuth(ctx context.Context, info *core.HostInfo) error {
 		default:
 		}
 
-		if err := tryPostgresqlConnect(ctx, info, dbname, timeout); err == nil {
-			fmt.Printf("[+] %s:%d postgresql %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+		server, err := postgresqlConnectSSL(ctx, info, flavour, username, dbname, timeout)
+		if err == nil {
+			result := &core.ScanResult{
+				Service:  server.Flavour,
+				Username: username,
+				Password: info.Password,
+				Success:  true,
+				VulnType: "weak_password",
+				Metadata: server.Metadata(),
+			}
+			if server.AuthMethod == "trust" {
+				// trust 认证不校验密码，任意密码均可登录
+				result.VulnType = "unauth"
+			}
+			info.Report(result)
 			return nil
 		}
+		lastErr = err
+
+		if !isPgHbaReject(err) {
+			break
+		}
+	}
+
+	return fmt.Errorf("postgresql auth failed: %v", lastErr)
+}
+
+// postgresqlConnectSSL 按 sslmode 选项建立连接
+// 选项：sslmode=auto|disable|require（默认 auto：先明文，被拒绝且SSL连接可通过 pg_hba 时改用SSL并缓存）
+func postgresqlConnectSSL(ctx context.Context, info *core.HostInfo, flavour *pgFlavour, username, dbname string, timeout time.Duration) (*pgServerInfo, error) {
+	key := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	// 已确认要求SSL连接的目标，在一次扫描内按 host:port 缓存
+	sslRequired := info.Cache("postgresql.ssl")
+
+	switch info.Option("sslmode", "auto") {
+	case "disable":
+		return tryPostgresqlConnect(ctx, info, flavour, username, dbname, false, timeout)
+	case "require":
+		return tryPostgresqlConnect(ctx, info, flavour, username, dbname, true, timeout)
+	}
+
+	if _, required := sslRequired.Load(key); required {
+		return tryPostgresqlConnect(ctx, info, flavour, username, dbname, true, timeout)
+	}
+
+	server, err := tryPostgresqlConnect(ctx, info, flavour, username, dbname, false, timeout)
+	if pgErrorCode(err) != pgErrInvalidAuthSpec {
+		return server, err
+	}
+
+	// 明文连接被拒绝（28000）时可能是 pg_hba 只允许 hostssl，改用SSL重试；
+	// 只依据 SQLSTATE 判断，服务端 lc_messages 为其他语言时同样适用。
+	// SSL 连接通过认证或返回其他 SQLSTATE（如密码错误）说明 pg_hba 要求SSL
+	sslServer, sslErr := tryPostgresqlConnect(ctx, info, flavour, username, dbname, true, timeout)
+	if code := pgErrorCode(sslErr); sslErr == nil || (code != "" && code != pgErrInvalidAuthSpec) {
+		sslRequired.Store(key, true)
+		return sslServer, sslErr
 	}
+	return server, err
+}
+
+// pgErrorCode 返回服务端错误的 SQLSTATE，非服务端错误返回空字符串
+func pgErrorCode(err error) string {
+	if pgErr, ok := err.(*pgError); ok {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// isPgHbaReject 判断是否为 pg_hba 规则拒绝（换一个数据库可能被允许）；
+// 只按 SQLSTATE 判断，服务端设置了非英文 lc_messages 时同样适用，密码错误为 28P01 不受影响
+func isPgHbaReject(err error) bool {
+	return pgErrorCode(err) == pgErrInvalidAuthSpec
+}
+
+// pgServerInfo 认证成功后获取的服务端信息
+type pgServerInfo struct {
+	Flavour    string
+	Version    string
+	Database   string
+	AuthMethod string
+	SSL        bool
+	Note       string
+}
 
-	return fmt.Errorf("postgresql auth failed: all connection attempts failed")
+// Metadata 转换为结果元数据
+func (s *pgServerInfo) Metadata() map[string]string {
+	metadata := map[string]string{
+		"version":  s.Version,
+		"database": s.Database,
+		"auth":     s.AuthMethod,
+		"ssl":      strconv.FormatBool(s.SSL),
+	}
+	if s.Note != "" {
+		metadata["note"] = s.Note
+	}
+	return metadata
 }
 
 // tryPostgresqlConnect 尝试连接PostgreSQL
-func tryPostgresqlConnect(ctx context.Context, info *core.HostInfo, dbname string, timeout time.Duration) error {
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+func tryPostgresqlConnect(ctx context.Context, info *core.HostInfo, flavour *pgFlavour, username, dbname string, useSSL bool, timeout time.Duration) (*pgServerInfo, error) {
+	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	conn, err := dialPostgres(ctx, addr, timeout)
+	if err != nil {
+		return nil, err
 	}
+	defer conn.Close()
 
-	// PostgreSQL连接字符串格式
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable connect_timeout=%d",
-		info.Host, info.Port, info.Username, info.Password, dbname, int(timeout.Seconds()))
+	if useSSL {
+		if err := conn.requestSSL(info.Host); err != nil {
+			return nil, fmt.Errorf("ssl negotiation failed: %v", err)
+		}
+	}
+
+	// openGauss 需要 3.51 协议版本才会下发 sha256 迭代次数
+	protocol := uint32(pgProtocolVersion)
+	if flavour.Name == "opengauss" {
+		protocol = pgProtocolVersionGauss
+	}
 
-	db, err := sql.Open("postgres", dsn)
+	if err := conn.startup(username, dbname, protocol); err != nil {
+		return nil, err
+	}
+
+	server := &pgServerInfo{Flavour: flavour.Name, Database: dbname, SSL: conn.ssl}
+	err = conn.authenticate(username, info.Password)
+	server.AuthMethod = conn.authMethod
 	if err != nil {
-		return err
+		// 数据库不存在的错误在认证通过之后才会返回，说明凭据有效
+		if pgErr, ok := err.(*pgError); ok && pgErr.Code == pgErrUnknownDatabase {
+			server.Note = fmt.Sprintf("database %s does not exist", dbname)
+			server.Flavour = detectPgFlavour(conn, "", flavour.Name)
+			return server, nil
+		}
+		return nil, err
+	}
+
+	server.Version = conn.params["server_version"]
+
+	// 通过 version() 识别实际产品
+	versionText := ""
+	if row, err := conn.queryRow("SELECT version()"); err == nil && len(row) > 0 {
+		versionText = row[0]
 	}
-	defer db.Close()
+	server.Flavour = detectPgFlavour(conn, versionText, flavour.Name)
+	return server, nil
+}
 
-	// 使用传入的context进行连接测试
-	return db.PingContext(ctx)
+// detectPgFlavour 根据认证方式、ParameterStatus 和 version() 识别产品类型，无法识别时返回 fallback
+func detectPgFlavour(conn *pgConn, versionText, fallback string) string {
+	if _, ok := conn.params["crdb_version"]; ok {
+		return "cockroachdb"
+	}
+	// MogDB 等 openGauss 衍生版同样使用专有的 sha256 认证
+	if conn.gauss || strings.Contains(versionText, "MogDB") {
+		return "opengauss"
+	}
+	for _, flavour := range pgFlavours {
+		if versionText != "" && strings.Contains(versionText, flavour.Keyword) {
+			return flavour.Name
+		}
+	}
+	return fallback
 }
 
 // 注册插件
 func init() {
-	core.GlobalRegistry.Register("postgresql", PostgresqlScan)
+	for _, flavour := range pgFlavours {
+		core.GlobalRegistry.Register(flavour.Name, PostgresqlScan)
+	}
 }
@@ -0,0 +1,429 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xdg-go/scram"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PostgreSQL 协议常量
+const (
+	pgProtocolVersion      = 196608 // 3.0
+	pgProtocolVersionGauss = 196659 // 3.51，openGauss 在该版本下发送 sha256 迭代次数
+	pgSSLRequestCode       = 80877103
+
+	// 认证请求类型
+	pgAuthOK              = 0
+	pgAuthCleartext       = 3
+	pgAuthMD5             = 5
+	pgAuthSASL            = 10 // openGauss 中同一编号表示 sha256 认证
+	pgAuthSASLContinue    = 11 // openGauss 中同一编号表示 md5_sha256 认证
+	pgAuthSASLFinal       = 12
+	gaussDefaultIteration = 2048
+
+	// openGauss 密码存储方式
+	gaussPlainPassword  = 0
+	gaussMD5Password    = 1
+	gaussSHA256Password = 2
+)
+
+// pgError 服务端返回的 ErrorResponse
+type pgError struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *pgError) Error() string {
+	return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
+
+// pgConn 精简的 PostgreSQL 前端协议连接，只实现认证所需的部分
+type pgConn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	params     map[string]string // ParameterStatus
+	authMethod string            // 服务端要求的认证方式
+	gauss      bool              // 服务端使用 openGauss 专有认证
+	ssl        bool              // 连接已升级为TLS
+}
+
+// dialPostgres 建立TCP连接
+func dialPostgres(ctx context.Context, addr string, timeout time.Duration) (*pgConn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	return &pgConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		params: make(map[string]string),
+	}, nil
+}
+
+// requestSSL 发送 SSLRequest，服务端同意后升级为TLS连接
+func (c *pgConn) requestSSL(host string) error {
+	request := binary.BigEndian.AppendUint32(nil, 8)
+	request = binary.BigEndian.AppendUint32(request, pgSSLRequestCode)
+	if _, err := c.conn.Write(request); err != nil {
+		return err
+	}
+
+	response, err := c.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if response != 'S' {
+		return fmt.Errorf("server does not support SSL")
+	}
+
+	tlsConn := tls.Client(c.conn, &tls.Config{
+		InsecureSkipVerify: true, // 跳过证书验证
+		ServerName:         host,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	c.ssl = true
+	return nil
+}
+
+// Close 发送 Terminate 并关闭连接
+func (c *pgConn) Close() error {
+	c.send('X', nil)
+	return c.conn.Close()
+}
+
+// send 发送带类型字节的消息
+func (c *pgConn) send(msgType byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	buf[0] = msgType
+	binary.BigEndian.PutUint32(buf[1:5], uint32(4+len(payload)))
+	copy(buf[5:], payload)
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// receive 读取一条后端消息
+func (c *pgConn) receive() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := int(binary.BigEndian.Uint32(header[1:5])) - 4
+	if length < 0 || length > 1<<20 {
+		return 0, nil, fmt.Errorf("invalid message length: %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// startup 发送 StartupMessage
+func (c *pgConn) startup(user, database string, protocol uint32) error {
+	var body []byte
+	body = binary.BigEndian.AppendUint32(body, protocol)
+	for _, kv := range [][2]string{{"user", user}, {"database", database}, {"client_encoding", "UTF8"}} {
+		body = append(body, kv[0]...)
+		body = append(body, 0)
+		body = append(body, kv[1]...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+
+	buf := binary.BigEndian.AppendUint32(nil, uint32(4+len(body)))
+	_, err := c.conn.Write(append(buf, body...))
+	return err
+}
+
+// authenticate 完成认证流程，直到 ReadyForQuery
+func (c *pgConn) authenticate(user, password string) error {
+	var sasl *scram.ClientConversation
+
+	for {
+		msgType, payload, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		switch msgType {
+		case 'R':
+			if len(payload) < 4 {
+				return fmt.Errorf("invalid authentication request")
+			}
+			code := binary.BigEndian.Uint32(payload[:4])
+			data := payload[4:]
+
+			switch {
+			case code == pgAuthOK:
+				if c.authMethod == "" {
+					c.authMethod = "trust"
+				}
+			case code == pgAuthCleartext:
+				c.authMethod = "password"
+				err = c.sendPassword(password)
+			case code == pgAuthMD5:
+				c.authMethod = "md5"
+				if len(data) < 4 {
+					return fmt.Errorf("invalid md5 salt")
+				}
+				err = c.sendPassword(pgMD5Password(user, password, data[:4]))
+			case code == pgAuthSASL && isGaussAuthRequest(data):
+				c.gauss = true
+				err = c.gaussAuth(user, password, data)
+			case code == pgAuthSASL:
+				sasl, err = c.startSASL(password, data)
+			case code == pgAuthSASLContinue && sasl != nil:
+				err = c.continueSASL(sasl, data)
+			case code == pgAuthSASLFinal && sasl != nil:
+				if _, err = sasl.Step(string(data)); err != nil {
+					return fmt.Errorf("scram server signature verification failed: %v", err)
+				}
+			default:
+				return fmt.Errorf("unsupported authentication method: %d", code)
+			}
+			if err != nil {
+				return err
+			}
+
+		case 'S':
+			// ParameterStatus
+			parts := strings.SplitN(string(payload), "\x00", 3)
+			if len(parts) >= 2 {
+				c.params[parts[0]] = parts[1]
+			}
+
+		case 'E':
+			return parsePgError(payload)
+
+		case 'Z':
+			return nil
+
+		case 'K', 'N', 'v':
+			// BackendKeyData、NoticeResponse、NegotiateProtocolVersion
+		default:
+			return fmt.Errorf("unexpected message type: %q", msgType)
+		}
+	}
+}
+
+// queryRow 使用简单查询协议执行语句，返回第一行数据
+func (c *pgConn) queryRow(query string) ([]string, error) {
+	if err := c.send('Q', append([]byte(query), 0)); err != nil {
+		return nil, err
+	}
+
+	var row []string
+	var queryErr error
+	for {
+		msgType, payload, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msgType {
+		case 'D':
+			if row == nil {
+				row = parsePgDataRow(payload)
+			}
+		case 'E':
+			queryErr = parsePgError(payload)
+		case 'Z':
+			return row, queryErr
+		}
+	}
+}
+
+// sendPassword 发送 PasswordMessage
+func (c *pgConn) sendPassword(password string) error {
+	return c.send('p', append([]byte(password), 0))
+}
+
+// startSASL 开始 SCRAM-SHA-256 认证
+func (c *pgConn) startSASL(password string, data []byte) (*scram.ClientConversation, error) {
+	mechanisms := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	if !slices.Contains(mechanisms, "SCRAM-SHA-256") {
+		return nil, fmt.Errorf("unsupported SASL mechanisms: %v", mechanisms)
+	}
+	c.authMethod = "scram-sha-256"
+
+	// PostgreSQL 忽略 SCRAM 消息中的用户名
+	client, err := scram.SHA256.NewClient("", password, "")
+	if err != nil {
+		client, err = scram.SHA256.NewClientUnprepped("", password, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conv := client.NewConversation()
+	first, err := conv.Step("")
+	if err != nil {
+		return nil, err
+	}
+
+	// SASLInitialResponse：机制名 + 长度 + 数据
+	payload := append([]byte("SCRAM-SHA-256"), 0)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(first)))
+	payload = append(payload, first...)
+	return conv, c.send('p', payload)
+}
+
+// continueSASL 处理 SASLContinue
+func (c *pgConn) continueSASL(conv *scram.ClientConversation, data []byte) error {
+	response, err := conv.Step(string(data))
+	if err != nil {
+		return err
+	}
+	return c.send('p', []byte(response))
+}
+
+// isGaussAuthRequest 判断认证请求是否为 openGauss 的 sha256 认证
+// PostgreSQL 的 SASL 请求以机制名开头，openGauss 以4字节的密码存储方式开头
+func isGaussAuthRequest(data []byte) bool {
+	if len(data) < 4 || data[0] != 0 || data[1] != 0 || data[2] != 0 {
+		return false
+	}
+	return data[3] <= gaussSHA256Password
+}
+
+// gaussAuth 处理 openGauss 的 sha256 认证请求
+func (c *pgConn) gaussAuth(user, password string, data []byte) error {
+	storedMethod := binary.BigEndian.Uint32(data[:4])
+	data = data[4:]
+
+	switch storedMethod {
+	case gaussMD5Password:
+		c.authMethod = "md5"
+		if len(data) < 4 {
+			return fmt.Errorf("invalid md5 salt")
+		}
+		return c.sendPassword(pgMD5Password(user, password, data[:4]))
+
+	case gaussPlainPassword, gaussSHA256Password:
+		c.authMethod = "sha256"
+		if len(data) < 72 {
+			return fmt.Errorf("invalid sha256 authentication request")
+		}
+		random64code := string(data[:64])
+		token := string(data[64:72])
+		iteration := gaussDefaultIteration
+		if len(data) >= 76 {
+			iteration = int(binary.BigEndian.Uint32(data[72:76]))
+		}
+
+		proof, err := gaussSHA256Proof(password, random64code, token, iteration)
+		if err != nil {
+			return err
+		}
+		return c.sendPassword(proof)
+	}
+
+	return fmt.Errorf("unsupported openGauss password stored method: %d", storedMethod)
+}
+
+// gaussSHA256Proof 计算 openGauss sha256 认证的客户端证明（RFC5802 变体）
+func gaussSHA256Proof(password, random64code, token string, iteration int) (string, error) {
+	salt, err := hex.DecodeString(random64code)
+	if err != nil {
+		return "", fmt.Errorf("invalid random code: %v", err)
+	}
+	tokenBytes, err := hex.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %v", err)
+	}
+
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iteration, 32, sha1.New)
+	clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	signature := hmacSHA256(storedKey[:], tokenBytes)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = signature[i] ^ clientKey[i]
+	}
+	return hex.EncodeToString(proof), nil
+}
+
+// hmacSHA256 计算 HMAC-SHA256
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// pgMD5Password 计算 md5 认证响应：md5(md5(password + user) + salt)
+func pgMD5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// parsePgError 解析 ErrorResponse 字段
+func parsePgError(payload []byte) *pgError {
+	pgErr := &pgError{}
+	for _, field := range strings.Split(string(payload), "\x00") {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'S':
+			pgErr.Severity = field[1:]
+		case 'C':
+			pgErr.Code = field[1:]
+		case 'M':
+			pgErr.Message = field[1:]
+		}
+	}
+	return pgErr
+}
+
+// parsePgDataRow 解析 DataRow 消息
+func parsePgDataRow(payload []byte) []string {
+	if len(payload) < 2 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(payload[:2]))
+	payload = payload[2:]
+
+	row := make([]string, 0, count)
+	for i := 0; i < count && len(payload) >= 4; i++ {
+		length := int(int32(binary.BigEndian.Uint32(payload[:4])))
+		payload = payload[4:]
+		if length < 0 {
+			row = append(row, "")
+			continue
+		}
+		if length > len(payload) {
+			break
+		}
+		row = append(row, string(payload[:length]))
+		payload = payload[length:]
+	}
+	return row
+}
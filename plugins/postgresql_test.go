@@ -0,0 +1,25 @@
+package plugins
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsPgHbaReject(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgError{Severity: "FATAL", Code: "28000", Message: `no pg_hba.conf entry for host "10.0.0.1", user "postgres", database "postgres", no encryption`}, true},
+		// lc_messages 为中文时消息中不一定包含 pg_hba.conf
+		{&pgError{Severity: "致命错误", Code: "28000", Message: `主机 "10.0.0.1"，用户 "postgres"，数据库 "postgres" 没有匹配的访问规则`}, true},
+		{&pgError{Severity: "FATAL", Code: "28P01", Message: `password authentication failed for user "postgres"`}, false},
+		{&pgError{Severity: "致命错误", Code: "28P01", Message: `用户 "postgres" 密码认证失败`}, false},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := isPgHbaReject(tt.err); got != tt.want {
+			t.Errorf("isPgHbaReject(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -3,16 +3,16 @@ package plugins
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
 	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"strings"
 	"time"
 
 	"github.com/zan8in/leo/internal/core"
-	"golang.org/x/crypto/md4"
+	"github.com/zan8in/leo/internal/ntlm"
 )
 
 // RDP协议常量
@@ -49,32 +49,40 @@ const (
 	TYPE_RDP_NEG_RSP     = 0x02
 	TYPE_RDP_NEG_FAILURE = 0x03
 
-	// NLA认证状态
-	NLA_AUTH_PKG_NAME = "NTLM"
+	// RDP协商失败码
+	SSL_REQUIRED_BY_SERVER                = 0x00000001
+	SSL_NOT_ALLOWED_BY_SERVER             = 0x00000002
+	SSL_CERT_NOT_ON_SERVER                = 0x00000003
+	INCONSISTENT_FLAGS                    = 0x00000004
+	HYBRID_REQUIRED_BY_SERVER             = 0x00000005
+	SSL_WITH_USER_AUTH_REQUIRED_BY_SERVER = 0x00000006
 )
 
-// RDP连接信息
-type RDPConnection struct {
-	conn       net.Conn
-	tlsConn    *tls.Conn
-	protocols  uint32
-	supportNLA bool
-	supportTLS bool
-	rdpVersion uint32
-	target     string
-	username   string
-	password   string
-	ctx        context.Context
+// rdpNegotiationFailure 服务端返回的 RDP_NEG_FAILURE
+type rdpNegotiationFailure struct {
+	Code uint32
+}
+
+func (e *rdpNegotiationFailure) Error() string {
+	return fmt.Sprintf("RDP negotiation failed with code: %d", e.Code)
 }
 
-// NTLM消息类型
-type NTLMMessage struct {
-	Signature   [8]byte
-	MessageType uint32
-	Data        []byte
+// errRdpCredentialsRequired RDP 不存在未授权访问，需要凭据
+var errRdpCredentialsRequired = errors.New("RDP requires credentials")
+
+// RDP连接信息
+type RDPConnection struct {
+	conn     net.Conn
+	tlsConn  *tls.Conn
+	protocol uint32 // 服务端选择的安全协议
+	host     string
+	target   string
+	timeout  time.Duration
+	ctx      context.Context
 }
 
 // RdpScan RDP弱口令扫描插件
+// 选项：spnego=true（NTLM 令牌使用 SPNEGO 封装）
 func RdpScan(info *core.HostInfo) error {
 	// 从 info.Context 获取上下文，如果没有则创建默认超时上下文
 	ctx := info.Context
@@ -84,6 +92,11 @@ func RdpScan(info *core.HostInfo) error {
 		defer cancel()
 	}
 
+	timeout := info.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
 	// 检查上下文是否已取消
 	select {
 	case <-ctx.Done():
@@ -91,49 +104,79 @@ func RdpScan(info *core.HostInfo) error {
 	default:
 	}
 
-	// 构建目标地址
-	target := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	// 未提供凭据时上报安全配置，返回错误使引擎继续弱口令检测
+	if info.Username == "" && info.Password == "" {
+		posture, err := probeRdpPosture(ctx, info, timeout)
+		if err != nil {
+			return fmt.Errorf("RDP probe failed: %v", err)
+		}
+		info.Report(&core.ScanResult{
+			Service:  "rdp",
+			Success:  true,
+			VulnType: "info",
+			Metadata: posture.Metadata(),
+		})
+		return errRdpCredentialsRequired
+	}
 
 	// 创建RDP连接
-	rdpConn, err := NewRDPConnection(target, ctx)
+	rdpConn, err := NewRDPConnection(ctx, info.Host, info.Port, timeout)
 	if err != nil {
 		return fmt.Errorf("RDP connection failed: %v", err)
 	}
 	defer rdpConn.Close()
 
 	// 执行RDP协议协商
-	if err := rdpConn.Negotiate(); err != nil {
+	if err := rdpConn.Negotiate(PROTOCOL_SSL | PROTOCOL_HYBRID | PROTOCOL_HYBRID_EX); err != nil {
 		return fmt.Errorf("RDP negotiation failed: %v", err)
 	}
 
-	// 如果提供了用户名和密码，尝试认证
-	if info.Username != "" || info.Password != "" {
-		rdpConn.username = info.Username
-		rdpConn.password = info.Password
-
-		if err := rdpConn.Authenticate(); err != nil {
-			return fmt.Errorf("RDP authentication failed for %s:%s - %v", info.Username, info.Password, err)
-		}
-		// 认证成功
-		fmt.Printf("[+] RDP %s:%d %s:%s\n", info.Host, info.Port, info.Username, info.Password)
-		return nil
+	user, domain := ntlmAccount(info)
+	client := &ntlm.Client{
+		User:      user,
+		Password:  info.Password,
+		Domain:    domain,
+		TargetSPN: "TERMSRV/" + info.Host,
+	}
+	note, err := rdpConn.Authenticate(client, info.OptionBool("spnego", false))
+	if err != nil {
+		return fmt.Errorf("RDP authentication failed for %s:%s - %w", info.Username, info.Password, err)
 	}
 
-	// 检测到 RDP 服务但未提供凭据
+	// 认证成功
+	metadata := map[string]string{"auth": "nla"}
+	if client.Domain != "" {
+		metadata["domain"] = client.Domain
+	}
+	if note != "" {
+		metadata["note"] = note
+	}
+	info.Report(&core.ScanResult{
+		Service:  "rdp",
+		Username: info.Username,
+		Password: info.Password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: metadata,
+	})
 	return nil
 }
 
 // NewRDPConnection 创建新的RDP连接
-func NewRDPConnection(target string, ctx context.Context) (*RDPConnection, error) {
-	conn, err := net.DialTimeout("tcp", target, 10*time.Second)
+func NewRDPConnection(ctx context.Context, host string, port int, timeout time.Duration) (*RDPConnection, error) {
+	target := fmt.Sprintf("%s:%d", host, port)
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
 	if err != nil {
 		return nil, err
 	}
 
 	return &RDPConnection{
-		conn:   conn,
-		target: target,
-		ctx:    ctx,
+		conn:    conn,
+		host:    host,
+		target:  target,
+		timeout: timeout,
+		ctx:     ctx,
 	}, nil
 }
 
@@ -147,23 +190,28 @@ func (r *RDPConnection) Close() {
 	}
 }
 
-// Negotiate 执行RDP协议协商
-func (r *RDPConnection) Negotiate() error {
+// NLA 服务端是否选择了 CredSSP（HYBRID 或 HYBRID_EX）
+func (r *RDPConnection) NLA() bool {
+	return r.protocol&(PROTOCOL_HYBRID|PROTOCOL_HYBRID_EX) != 0
+}
+
+// Negotiate 执行RDP协议协商，服务端选择 TLS 或 CredSSP 时完成 TLS 握手
+func (r *RDPConnection) Negotiate(requested uint32) error {
 	// 设置读写超时
-	r.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
 
 	// 发送连接请求
-	if err := r.sendConnectionRequest(); err != nil {
+	if err := r.sendConnectionRequest(requested); err != nil {
 		return fmt.Errorf("failed to send connection request: %v", err)
 	}
 
 	// 读取连接确认
 	if err := r.readConnectionConfirm(); err != nil {
-		return fmt.Errorf("failed to read connection confirm: %v", err)
+		return fmt.Errorf("failed to read connection confirm: %w", err)
 	}
 
-	// 如果支持TLS，建立TLS连接
-	if r.supportTLS {
+	// SSL、HYBRID、HYBRID_EX 均需先建立TLS连接
+	if r.protocol != PROTOCOL_RDP {
 		if err := r.establishTLS(); err != nil {
 			return fmt.Errorf("failed to establish TLS: %v", err)
 		}
@@ -173,9 +221,9 @@ func (r *RDPConnection) Negotiate() error {
 }
 
 // sendConnectionRequest 发送RDP连接请求
-func (r *RDPConnection) sendConnectionRequest() error {
+func (r *RDPConnection) sendConnectionRequest(requested uint32) error {
 	// 构建RDP协商请求
-	negData := r.buildNegotiationRequest()
+	negData := r.buildNegotiationRequest(requested)
 
 	// 构建X.224连接请求
 	x224Data := r.buildX224ConnectionRequest(negData)
@@ -189,7 +237,7 @@ func (r *RDPConnection) sendConnectionRequest() error {
 }
 
 // buildNegotiationRequest 构建协商请求
-func (r *RDPConnection) buildNegotiationRequest() []byte {
+func (r *RDPConnection) buildNegotiationRequest(requested uint32) []byte {
 	buf := new(bytes.Buffer)
 
 	// RDP协商请求
@@ -198,8 +246,7 @@ func (r *RDPConnection) buildNegotiationRequest() []byte {
 	binary.Write(buf, binary.LittleEndian, uint16(8))               // Length
 
 	// 请求的协议
-	requestedProtocols := uint32(PROTOCOL_SSL | PROTOCOL_HYBRID)
-	binary.Write(buf, binary.LittleEndian, requestedProtocols)
+	binary.Write(buf, binary.LittleEndian, requested)
 
 	return buf.Bytes()
 }
@@ -241,7 +288,7 @@ func (r *RDPConnection) buildTPKT(data []byte) []byte {
 func (r *RDPConnection) readConnectionConfirm() error {
 	// 读取TPKT头部
 	tpktHeader := make([]byte, 4)
-	if _, err := r.conn.Read(tpktHeader); err != nil {
+	if _, err := io.ReadFull(r.conn, tpktHeader); err != nil {
 		return err
 	}
 
@@ -250,28 +297,26 @@ func (r *RDPConnection) readConnectionConfirm() error {
 	}
 
 	// 获取数据长度
-	length := binary.BigEndian.Uint16(tpktHeader[2:4]) - 4
+	length := binary.BigEndian.Uint16(tpktHeader[2:4])
+	if length < 4+7 {
+		return fmt.Errorf("invalid TPKT length: %d", length)
+	}
 
 	// 读取剩余数据
-	data := make([]byte, length)
-	if _, err := r.conn.Read(data); err != nil {
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(r.conn, data); err != nil {
 		return err
 	}
 
-	// 解析X.224连接确认
-	if len(data) < 7 {
-		return fmt.Errorf("invalid X.224 connection confirm length")
-	}
-
 	if data[1] != X224_TPDU_CONNECTION_CONFIRM {
 		return fmt.Errorf("invalid X.224 TPDU type: %d", data[1])
 	}
 
-	// 解析协商响应
+	// 没有协商响应的旧版服务端只支持标准RDP安全
 	if len(data) > 7 {
 		return r.parseNegotiationResponse(data[7:])
 	}
-
+	r.protocol = PROTOCOL_RDP
 	return nil
 }
 
@@ -284,16 +329,13 @@ func (r *RDPConnection) parseNegotiationResponse(data []byte) error {
 	negType := data[0]
 	switch negType {
 	case TYPE_RDP_NEG_RSP:
-		// 协商成功
-		r.protocols = binary.LittleEndian.Uint32(data[4:8])
-		r.supportTLS = (r.protocols & PROTOCOL_SSL) != 0
-		r.supportNLA = (r.protocols & PROTOCOL_HYBRID) != 0
+		// 协商成功，selectedProtocol 为服务端选定的单个协议
+		r.protocol = binary.LittleEndian.Uint32(data[4:8])
 		return nil
 
 	case TYPE_RDP_NEG_FAILURE:
 		// 协商失败
-		failureCode := binary.LittleEndian.Uint32(data[4:8])
-		return fmt.Errorf("RDP negotiation failed with code: %d", failureCode)
+		return &rdpNegotiationFailure{Code: binary.LittleEndian.Uint32(data[4:8])}
 
 	default:
 		return fmt.Errorf("unknown negotiation response type: %d", negType)
@@ -305,310 +347,27 @@ func (r *RDPConnection) establishTLS() error {
 	// 创建TLS配置
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true, // 跳过证书验证
-		ServerName:         strings.Split(r.target, ":")[0],
+		ServerName:         r.host,
 	}
 
 	// 建立TLS连接
 	r.tlsConn = tls.Client(r.conn, tlsConfig)
 
 	// 执行TLS握手
-	if err := r.tlsConn.Handshake(); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// Authenticate 执行认证
-func (r *RDPConnection) Authenticate() error {
-	if r.supportNLA {
-		// 使用NLA认证
-		return r.authenticateNLA()
-	} else {
-		// 使用标准RDP认证
-		return r.authenticateStandard()
-	}
-}
-
-// authenticateNLA 执行NLA认证
-func (r *RDPConnection) authenticateNLA() error {
-	// NLA认证使用NTLM over TLS
-	if !r.supportTLS {
-		return fmt.Errorf("NLA requires TLS but TLS is not supported")
-	}
-
-	// 执行NTLM认证
-	return r.performNTLMAuth()
-}
-
-// performNTLMAuth 执行NTLM认证
-func (r *RDPConnection) performNTLMAuth() error {
-	// 第一步：发送Type 1消息（协商消息）
-	type1Msg := r.createNTLMType1Message()
-	if err := r.sendNTLMMessage(type1Msg); err != nil {
-		return fmt.Errorf("failed to send NTLM Type 1 message: %v", err)
-	}
-
-	// 第二步：接收Type 2消息（挑战消息）
-	type2Msg, err := r.receiveNTLMMessage()
-	if err != nil {
-		return fmt.Errorf("failed to receive NTLM Type 2 message: %v", err)
-	}
-
-	// 第三步：发送Type 3消息（认证消息）
-	type3Msg := r.createNTLMType3Message(type2Msg)
-	if err := r.sendNTLMMessage(type3Msg); err != nil {
-		return fmt.Errorf("failed to send NTLM Type 3 message: %v", err)
-	}
-
-	// 验证认证结果
-	return r.verifyNTLMAuth()
-}
-
-// createNTLMType1Message 创建NTLM Type 1消息
-func (r *RDPConnection) createNTLMType1Message() []byte {
-	buf := new(bytes.Buffer)
-
-	// NTLM签名
-	buf.WriteString("NTLMSSP\x00")
-
-	// 消息类型 (Type 1)
-	binary.Write(buf, binary.LittleEndian, uint32(1))
-
-	// 标志
-	flags := uint32(0x62890235) // 标准NTLM标志
-	binary.Write(buf, binary.LittleEndian, flags)
-
-	// 域名长度和偏移（空）
-	binary.Write(buf, binary.LittleEndian, uint16(0)) // 长度
-	binary.Write(buf, binary.LittleEndian, uint16(0)) // 最大长度
-	binary.Write(buf, binary.LittleEndian, uint32(0)) // 偏移
-
-	// 工作站名长度和偏移（空）
-	binary.Write(buf, binary.LittleEndian, uint16(0)) // 长度
-	binary.Write(buf, binary.LittleEndian, uint16(0)) // 最大长度
-	binary.Write(buf, binary.LittleEndian, uint32(0)) // 偏移
-
-	return buf.Bytes()
-}
-
-// createNTLMType3Message 创建NTLM Type 3消息
-func (r *RDPConnection) createNTLMType3Message(type2Msg []byte) []byte {
-	// 解析Type 2消息获取挑战
-	challenge := r.extractChallenge(type2Msg)
-
-	// 计算响应
-	ntResponse := r.calculateNTResponse(challenge, r.password)
-	lmResponse := r.calculateLMResponse(challenge, r.password)
-
-	buf := new(bytes.Buffer)
-
-	// NTLM签名
-	buf.WriteString("NTLMSSP\x00")
-
-	// 消息类型 (Type 3)
-	binary.Write(buf, binary.LittleEndian, uint32(3))
-
-	// 计算各字段的偏移
-	baseOffset := uint32(64) // 固定头部长度
-	currentOffset := baseOffset
-
-	// LM响应
-	lmLen := uint16(len(lmResponse))
-	binary.Write(buf, binary.LittleEndian, lmLen)         // 长度
-	binary.Write(buf, binary.LittleEndian, lmLen)         // 最大长度
-	binary.Write(buf, binary.LittleEndian, currentOffset) // 偏移
-	currentOffset += uint32(lmLen)
-
-	// NT响应
-	ntLen := uint16(len(ntResponse))
-	binary.Write(buf, binary.LittleEndian, ntLen)         // 长度
-	binary.Write(buf, binary.LittleEndian, ntLen)         // 最大长度
-	binary.Write(buf, binary.LittleEndian, currentOffset) // 偏移
-	currentOffset += uint32(ntLen)
-
-	// 域名（空）
-	binary.Write(buf, binary.LittleEndian, uint16(0))     // 长度
-	binary.Write(buf, binary.LittleEndian, uint16(0))     // 最大长度
-	binary.Write(buf, binary.LittleEndian, currentOffset) // 偏移
-
-	// 用户名
-	usernameUTF16 := r.stringToUTF16LE(r.username)
-	userLen := uint16(len(usernameUTF16))
-	binary.Write(buf, binary.LittleEndian, userLen)       // 长度
-	binary.Write(buf, binary.LittleEndian, userLen)       // 最大长度
-	binary.Write(buf, binary.LittleEndian, currentOffset) // 偏移
-	currentOffset += uint32(userLen)
-
-	// 工作站名（空）
-	binary.Write(buf, binary.LittleEndian, uint16(0))     // 长度
-	binary.Write(buf, binary.LittleEndian, uint16(0))     // 最大长度
-	binary.Write(buf, binary.LittleEndian, currentOffset) // 偏移
-
-	// 会话密钥（空）
-	binary.Write(buf, binary.LittleEndian, uint16(0))     // 长度
-	binary.Write(buf, binary.LittleEndian, uint16(0))     // 最大长度
-	binary.Write(buf, binary.LittleEndian, currentOffset) // 偏移
-
-	// 标志
-	binary.Write(buf, binary.LittleEndian, uint32(0x62890235))
-
-	// 添加数据
-	buf.Write(lmResponse)
-	buf.Write(ntResponse)
-	buf.Write(usernameUTF16)
-
-	return buf.Bytes()
-}
-
-// extractChallenge 从Type 2消息中提取挑战
-func (r *RDPConnection) extractChallenge(type2Msg []byte) []byte {
-	if len(type2Msg) < 32 {
-		return make([]byte, 8) // 返回空挑战
-	}
-	return type2Msg[24:32] // 挑战位于偏移24-31
-}
-
-// calculateNTResponse 计算NT响应
-func (r *RDPConnection) calculateNTResponse(challenge []byte, password string) []byte {
-	// 将密码转换为UTF-16LE
-	passwordUTF16 := r.stringToUTF16LE(password)
-
-	// 计算MD4哈希
-	md4Hash := md4.New()
-	md4Hash.Write(passwordUTF16)
-	ntHash := md4Hash.Sum(nil)
-
-	// 使用DES加密挑战
-	return r.desEncrypt(ntHash, challenge)
-}
-
-// calculateLMResponse 计算LM响应
-func (r *RDPConnection) calculateLMResponse(challenge []byte, password string) []byte {
-	// LM响应通常为空或使用NT响应
-	return make([]byte, 24)
-}
-
-// stringToUTF16LE 将字符串转换为UTF-16LE
-func (r *RDPConnection) stringToUTF16LE(s string) []byte {
-	runes := []rune(s)
-	buf := new(bytes.Buffer)
-
-	for _, r := range runes {
-		binary.Write(buf, binary.LittleEndian, uint16(r))
-	}
-
-	return buf.Bytes()
-}
-
-// desEncrypt 使用DES加密（简化实现）
-func (r *RDPConnection) desEncrypt(key, data []byte) []byte {
-	// 这里应该实现完整的DES加密
-	// 为了简化，返回固定长度的响应
-	response := make([]byte, 24)
-
-	// 使用MD5作为简化的加密方法
-	hash := md5.New()
-	hash.Write(key)
-	hash.Write(data)
-	copy(response, hash.Sum(nil))
-
-	return response
-}
-
-// sendNTLMMessage 发送NTLM消息
-func (r *RDPConnection) sendNTLMMessage(msg []byte) error {
-	var conn net.Conn
-	if r.tlsConn != nil {
-		conn = r.tlsConn
-	} else {
-		conn = r.conn
-	}
-
-	// 构建消息头部
-	header := make([]byte, 4)
-	binary.BigEndian.PutUint32(header, uint32(len(msg)))
-
-	// 发送头部和消息
-	if _, err := conn.Write(header); err != nil {
-		return err
-	}
-
-	_, err := conn.Write(msg)
-	return err
-}
-
-// receiveNTLMMessage 接收NTLM消息
-func (r *RDPConnection) receiveNTLMMessage() ([]byte, error) {
-	var conn net.Conn
-	if r.tlsConn != nil {
-		conn = r.tlsConn
-	} else {
-		conn = r.conn
-	}
-
-	// 读取消息长度
-	header := make([]byte, 4)
-	if _, err := conn.Read(header); err != nil {
-		return nil, err
-	}
-
-	length := binary.BigEndian.Uint32(header)
-	if length > 65536 { // 防止过大的消息
-		return nil, fmt.Errorf("message too large: %d", length)
-	}
-
-	// 读取消息内容
-	msg := make([]byte, length)
-	if _, err := conn.Read(msg); err != nil {
-		return nil, err
-	}
-
-	return msg, nil
+	return r.tlsConn.HandshakeContext(r.ctx)
 }
 
-// verifyNTLMAuth 验证NTLM认证结果
-func (r *RDPConnection) verifyNTLMAuth() error {
-	// 尝试读取认证结果
-	var conn net.Conn
-	if r.tlsConn != nil {
-		conn = r.tlsConn
-	} else {
-		conn = r.conn
+// Authenticate 执行认证，返回凭据有效时的附加说明
+func (r *RDPConnection) Authenticate(client *ntlm.Client, spnego bool) (string, error) {
+	if !r.NLA() {
+		// 标准RDP认证（不使用NLA）需在完整会话建立后进行
+		// 由于复杂性，这里返回未实现错误
+		return "", errors.New("standard RDP authentication not implemented")
 	}
 
-	// 设置短超时来检查是否有错误响应
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
-
-	// 重置超时
-	conn.SetReadDeadline(time.Time{})
-
-	if err != nil {
-		// 如果是超时错误，可能表示认证成功（没有错误消息）
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return nil // 认证可能成功
-		}
-		return fmt.Errorf("authentication verification failed: %v", err)
-	}
-
-	// 检查响应内容
-	if n > 0 {
-		// 如果收到数据，可能是错误消息
-		return fmt.Errorf("authentication failed: received error response")
-	}
-
-	return nil
-}
-
-// authenticateStandard 执行标准RDP认证
-func (r *RDPConnection) authenticateStandard() error {
-	// 标准RDP认证（不使用NLA）
-	// 这种情况下，认证通常在RDP连接建立后进行
-	// 由于复杂性，这里返回未实现错误
-	return fmt.Errorf("standard RDP authentication not implemented")
+	// NLA认证：TLS之上的CredSSP，内层为NTLMv2
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+	return credsspAuth(r.tlsConn, client, spnego)
 }
 
 // 注册插件
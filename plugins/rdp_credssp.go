@@ -0,0 +1,245 @@
+package plugins
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/zan8in/leo/internal/core"
+	"github.com/zan8in/leo/internal/ntlm"
+)
+
+// credsspVersion 客户端使用的 CredSSP 版本（MS-CSSP 2.2.1）
+const credsspVersion = 6
+
+// credsspClientHashMagic 版本 5 及以上 pubKeyAuth 的哈希前缀
+const credsspClientHashMagic = "CredSSP Client-To-Server Binding Hash\x00"
+
+// credsspMaxMessage TSRequest 的最大长度
+const credsspMaxMessage = 64 * 1024
+
+// CredSSP 返回的 NTSTATUS 错误码
+const (
+	ntStatusLogonFailure     = 0xC000006D // 用户名或密码错误
+	ntStatusWrongPassword    = 0xC000006A
+	ntStatusAccountDisabled  = 0xC0000072
+	ntStatusPasswordExpired  = 0xC0000071 // 密码已过期（凭据有效）
+	ntStatusMustChange       = 0xC0000224 // 密码必须修改（凭据有效）
+	ntStatusAccountLockedOut = 0xC0000234
+)
+
+// errRdpLogonFailure 服务端拒绝凭据
+var errRdpLogonFailure = errors.New("logon failure")
+
+// tsRequest CredSSP 消息（MS-CSSP 2.2.1）
+type tsRequest struct {
+	Version     int         `asn1:"explicit,tag:0"`
+	NegoTokens  []negoToken `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte      `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte      `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int64       `asn1:"explicit,optional,tag:4"`
+	ClientNonce []byte      `asn1:"explicit,optional,tag:5"`
+}
+
+type negoToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+// subjectPublicKeyInfo 证书公钥信息，pubKeyAuth 使用其中的 SubjectPublicKey
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// credsspChallenge 发送 NTLM 协商令牌并返回服务端的 TSRequest 及其中的 CHALLENGE_MESSAGE
+func credsspChallenge(conn *tls.Conn, client *ntlm.Client, spnego bool) (*tsRequest, []byte, error) {
+	token := client.Negotiate()
+	if spnego {
+		var err error
+		if token, err = ntlm.WrapNegotiate(token); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := writeTSRequest(conn, &tsRequest{Version: credsspVersion, NegoTokens: []negoToken{{Token: token}}}); err != nil {
+		return nil, nil, err
+	}
+
+	challenge, err := readTSRequest(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if challenge.ErrorCode != 0 {
+		return challenge, nil, nil
+	}
+	if len(challenge.NegoTokens) == 0 {
+		return nil, nil, errors.New("credssp: missing challenge token")
+	}
+	challengeToken, err := ntlm.Unwrap(challenge.NegoTokens[0].Token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return challenge, challengeToken, nil
+}
+
+// credsspAuth 通过 CredSSP 完成 NTLM 认证并校验公钥绑定，不发送 authInfo（不会建立会话）
+// 返回凭据有效时的附加说明（如密码已过期）
+func credsspAuth(conn *tls.Conn, client *ntlm.Client, spnego bool) (string, error) {
+	publicKey, err := tlsSubjectPublicKey(conn)
+	if err != nil {
+		return "", err
+	}
+
+	challenge, challengeToken, err := credsspChallenge(conn, client, spnego)
+	if err != nil {
+		return "", err
+	}
+	if challenge.ErrorCode != 0 {
+		return classifyCredsspError(challenge.ErrorCode)
+	}
+
+	authenticate, err := client.Authenticate(challengeToken)
+	if err != nil {
+		return "", err
+	}
+	session, err := client.Session()
+	if err != nil {
+		return "", err
+	}
+	if spnego {
+		if authenticate, err = ntlm.WrapAuthenticate(authenticate, session); err != nil {
+			return "", err
+		}
+	}
+
+	// 版本 5 及以上对 nonce 和公钥的哈希签名，低版本直接加密公钥
+	request := &tsRequest{Version: credsspVersion, NegoTokens: []negoToken{{Token: authenticate}}}
+	if challenge.Version >= 5 {
+		request.ClientNonce = make([]byte, 32)
+		rand.Read(request.ClientNonce)
+		hash := sha256.New()
+		hash.Write([]byte(credsspClientHashMagic))
+		hash.Write(request.ClientNonce)
+		hash.Write(publicKey)
+		request.PubKeyAuth = session.Wrap(hash.Sum(nil))
+	} else {
+		request.PubKeyAuth = session.Wrap(publicKey)
+	}
+	if err := writeTSRequest(conn, request); err != nil {
+		return "", err
+	}
+
+	// 凭据有效时服务端返回自己的 pubKeyAuth，否则返回 errorCode 或直接断开连接
+	response, err := readTSRequest(conn)
+	if err != nil {
+		if isConnectionClosed(err) {
+			return "", errRdpLogonFailure
+		}
+		return "", err
+	}
+	if response.ErrorCode != 0 {
+		return classifyCredsspError(response.ErrorCode)
+	}
+	if len(response.PubKeyAuth) == 0 {
+		return "", errors.New("credssp: missing server pubKeyAuth")
+	}
+	return "", nil
+}
+
+// classifyCredsspError 按 NTSTATUS 区分凭据错误、账户锁定和需修改密码的有效凭据
+func classifyCredsspError(code int64) (string, error) {
+	status := uint32(code)
+	switch status {
+	case ntStatusPasswordExpired:
+		return "password expired", nil
+	case ntStatusMustChange:
+		return "password must change", nil
+	case ntStatusAccountLockedOut:
+		return "", fmt.Errorf("%w (NTSTATUS 0x%08X)", core.ErrAccountLocked, status)
+	case ntStatusLogonFailure, ntStatusWrongPassword:
+		return "", errRdpLogonFailure
+	case ntStatusAccountDisabled:
+		return "", fmt.Errorf("%w: account disabled", errRdpLogonFailure)
+	default:
+		return "", fmt.Errorf("credssp: NTSTATUS 0x%08X", status)
+	}
+}
+
+// tlsSubjectPublicKey 取出服务端证书的 SubjectPublicKey（不含算法标识）
+func tlsSubjectPublicKey(conn *tls.Conn) ([]byte, error) {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("credssp: server sent no certificate")
+	}
+
+	var info subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(certs[0].RawSubjectPublicKeyInfo, &info); err != nil {
+		return nil, err
+	}
+	return info.PublicKey.RightAlign(), nil
+}
+
+func writeTSRequest(w io.Writer, request *tsRequest) error {
+	data, err := asn1.Marshal(*request)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readTSRequest 按 DER 长度读取完整的 TSRequest
+func readTSRequest(r io.Reader) (*tsRequest, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0x30 {
+		return nil, fmt.Errorf("credssp: unexpected tag 0x%02x", header[0])
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 3 {
+			return nil, errors.New("credssp: invalid length")
+		}
+		extra := make([]byte, size)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > credsspMaxMessage {
+		return nil, fmt.Errorf("credssp: message too large: %d", length)
+	}
+
+	data := make([]byte, len(header)+length)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[len(header):]); err != nil {
+		return nil, err
+	}
+
+	request := new(tsRequest)
+	if _, err := asn1.Unmarshal(data, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// isConnectionClosed 服务端在认证失败后直接断开连接
+func isConnectionClosed(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || (errors.As(err, &opErr) && !opErr.Timeout())
+}
@@ -0,0 +1,139 @@
+package plugins
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+	"github.com/zan8in/leo/internal/ntlm"
+)
+
+// rdpProbeProtocols 逐个探测的安全协议
+var rdpProbeProtocols = []struct {
+	name     string
+	protocol uint32
+}{
+	{"rdp", PROTOCOL_RDP},
+	{"tls", PROTOCOL_SSL},
+	{"credssp", PROTOCOL_HYBRID},
+	{"credssp-ex", PROTOCOL_HYBRID_EX},
+	{"rdstls", PROTOCOL_RDSTLS},
+}
+
+// rdpPosture 无需凭据即可获取的 RDP 安全配置
+type rdpPosture struct {
+	Protocols   []string
+	NLA         string // required / optional / unsupported
+	Certificate *x509.Certificate
+	Challenge   *ntlm.ChallengeMessage
+}
+
+// probeRdpPosture 对每种安全协议单独协商，记录服务端接受的协议、TLS 证书和 NTLM 挑战中的主机信息
+func probeRdpPosture(ctx context.Context, info *core.HostInfo, timeout time.Duration) (*rdpPosture, error) {
+	posture := &rdpPosture{NLA: "unsupported"}
+	var lastErr error
+	for _, probe := range rdpProbeProtocols {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		rdpConn, err := NewRDPConnection(ctx, info.Host, info.Port, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		err = rdpConn.Negotiate(probe.protocol)
+		if err == nil {
+			// 不支持协商的旧版服务端总是使用标准 RDP 安全，只有选定的协议与请求一致才算支持
+			if rdpConn.protocol == probe.protocol {
+				posture.Protocols = append(posture.Protocols, probe.name)
+				posture.inspect(rdpConn)
+			}
+		} else {
+			// 协商失败码说明服务端正常响应但不接受该协议
+			var failure *rdpNegotiationFailure
+			if !errors.As(err, &failure) {
+				lastErr = err
+			}
+		}
+		rdpConn.Close()
+	}
+
+	if len(posture.Protocols) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no security protocol accepted")
+		}
+		return nil, lastErr
+	}
+
+	// 支持 CredSSP 且拒绝标准 RDP 和纯 TLS 时，未通过 NLA 无法建立会话
+	if slices.Contains(posture.Protocols, "credssp") || slices.Contains(posture.Protocols, "credssp-ex") {
+		posture.NLA = "optional"
+		if !slices.Contains(posture.Protocols, "rdp") && !slices.Contains(posture.Protocols, "tls") {
+			posture.NLA = "required"
+		}
+	}
+	return posture, nil
+}
+
+// inspect 从已协商的连接中读取证书，CredSSP 连接额外获取 NTLM 挑战
+func (p *rdpPosture) inspect(rdpConn *RDPConnection) {
+	if rdpConn.tlsConn == nil {
+		return
+	}
+	if p.Certificate == nil {
+		if certs := rdpConn.tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			p.Certificate = certs[0]
+		}
+	}
+
+	if p.Challenge != nil || !rdpConn.NLA() {
+		return
+	}
+	rdpConn.conn.SetDeadline(time.Now().Add(rdpConn.timeout))
+	_, token, err := credsspChallenge(rdpConn.tlsConn, &ntlm.Client{}, false)
+	if err != nil || token == nil {
+		return
+	}
+	if challenge, err := ntlm.ParseChallenge(token); err == nil {
+		p.Challenge = challenge
+	}
+}
+
+// Metadata 转换为结果元数据
+func (p *rdpPosture) Metadata() map[string]string {
+	metadata := map[string]string{
+		"protocols": strings.Join(p.Protocols, ","),
+		"nla":       p.NLA,
+	}
+
+	if cert := p.Certificate; cert != nil {
+		metadata["cert_subject"] = cert.Subject.String()
+		metadata["cert_issuer"] = cert.Issuer.String()
+		metadata["cert_expiry"] = cert.NotAfter.Format("2006-01-02")
+	}
+
+	if challenge := p.Challenge; challenge != nil {
+		fields := map[string]uint16{
+			"netbios_domain":   ntlm.AvNbDomainName,
+			"netbios_computer": ntlm.AvNbComputerName,
+			"dns_domain":       ntlm.AvDNSDomainName,
+			"dns_computer":     ntlm.AvDNSComputerName,
+		}
+		for key, id := range fields {
+			if value := challenge.TargetInfo.String(id); value != "" {
+				metadata[key] = value
+			}
+		}
+		if challenge.Version != nil {
+			metadata["os_version"] = challenge.Version.String()
+		}
+	}
+	return metadata
+}
@@ -33,7 +33,11 @@ func RedisScan(info *core.HostInfo) error {
 	// 优先检测未授权访问（无密码访问）
 	if info.Username == "" && info.Password == "" {
 		if err := redisUnauth(info, ctx); err == nil {
-			fmt.Printf("[+] %s:%d redis unauthorized access\n", info.Host, info.Port)
+			info.Report(&core.ScanResult{
+				Service:  "redis",
+				Success:  true,
+				VulnType: "unauth",
+			})
 			return nil // 发现未授权访问，停止进一步检测
 		}
 	}
@@ -139,12 +143,17 @@ func redisAuth(info *core.HostInfo, parentCtx context.Context) error {
 	// 尝试ping测试连接
 	_, err := rdb.Ping(requestCtx).Result()
 	if err == nil {
-		// 认证成功，输出结果
-		if info.Password != "" {
-			fmt.Printf("[+] %s:%d redis :%s\n", info.Host, info.Port, info.Password)
-		} else {
-			fmt.Printf("[+] %s:%d redis no password\n", info.Host, info.Port)
+		// 认证成功，上报结果；Redis 只校验密码，空密码即未授权访问
+		result := &core.ScanResult{
+			Service:  "redis",
+			Password: info.Password,
+			Success:  true,
+			VulnType: "weak_password",
 		}
+		if info.Password == "" {
+			result.VulnType = "unauth"
+		}
+		info.Report(result)
 	}
 
 	return err
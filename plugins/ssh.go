@@ -139,8 +139,14 @@ func trySshConnect(ctx context.Context, addr string, config *ssh.ClientConfig, i
 	// 执行简单命令验证权限
 	err = session.Run("echo 'ssh_test'")
 	if err == nil {
-		// 认证成功，输出结果
-		fmt.Printf("[+] %s:%d ssh %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+		// 认证成功，上报结果
+		info.Report(&core.ScanResult{
+			Service:  "ssh",
+			Username: info.Username,
+			Password: info.Password,
+			Success:  true,
+			VulnType: "weak_password",
+		})
 	}
 
 	return err
@@ -2,12 +2,10 @@ package plugins
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/zan8in/leo/internal/core"
@@ -36,6 +34,7 @@ const (
 )
 
 // TelnetScan Telnet弱口令扫描函数
+// 选项：profiles=自定义设备配置文件 profile=强制使用的配置名称 enable=特权密码列表（逗号分隔）
 func TelnetScan(info *core.HostInfo) error {
 	if info.Port == 0 {
 		info.Port = 23 // Telnet默认端口
@@ -66,13 +65,19 @@ func TelnetScan(info *core.HostInfo) error {
 	default:
 	}
 
+	// 已识别为无需认证、仅密码或无密码账户的目标跳过重复尝试
+	target := loadTelnetTarget(info)
+	if skip, err := target.skip(info.Username, info.Password); skip {
+		return err
+	}
+
 	// 建立TCP连接
-	target := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
 	dialer := &net.Dialer{
 		Timeout: timeout,
 	}
 
-	conn, err := dialer.DialContext(requestCtx, "tcp", target)
+	conn, err := dialer.DialContext(requestCtx, "tcp", addr)
 	if err != nil {
 		return fmt.Errorf("telnet connection failed: %v", err)
 	}
@@ -85,23 +90,74 @@ func TelnetScan(info *core.HostInfo) error {
 	default:
 	}
 
-	// 创建Telnet客户端
+	profiles, err := loadTelnetProfiles(info.Option("profiles", ""))
+	if err != nil {
+		return err
+	}
+
+	// 创建Telnet客户端，选项协商在读取数据时完成
+	tconn := newTelnetConn(conn)
 	telnetClient := &TelnetClient{
-		conn:     conn,
-		reader:   bufio.NewReader(conn),
+		conn:     tconn,
+		reader:   bufio.NewReader(tconn),
 		ctx:      requestCtx,
 		timeout:  timeout,
 		username: info.Username,
 		password: info.Password,
+		profiles: profiles,
+	}
+	if name := info.Option("profile", ""); name != "" {
+		if telnetClient.profile = findTelnetProfile(profiles, name); telnetClient.profile == nil {
+			return fmt.Errorf("unknown telnet profile: %s", name)
+		}
 	}
 
 	// 执行Telnet认证
-	if err := telnetClient.Authenticate(); err != nil {
+	mode, err := telnetClient.Authenticate()
+	target.record(mode, info.Username, info.Password)
+	if err != nil {
 		return fmt.Errorf("telnet authentication failed for %s:%s - %v", info.Username, info.Password, err)
 	}
 
-	// 认证成功
-	fmt.Printf("[+] %s:%d telnet %s:%s\n", info.Host, info.Port, info.Username, info.Password)
+	metadata := map[string]string{
+		"profile": telnetClient.profile.Name,
+		"auth":    mode,
+	}
+	if secret, ok := telnetClient.Enable(info.OptionList("enable", nil)); ok {
+		metadata["privileged"] = "true"
+		metadata["severity"] = "high"
+		if secret != "" {
+			metadata["enable"] = secret
+		}
+	}
+
+	// 无需认证直接进入命令行
+	if mode == telnetAuthNone {
+		info.Report(&core.ScanResult{
+			Service:  "telnet",
+			Success:  true,
+			VulnType: "unauth",
+			Metadata: metadata,
+		})
+		return nil
+	}
+
+	// 认证成功，仅密码设备不记录用户名；无密码账户未发送密码，不记录本次字典中的密码
+	username, password := info.Username, info.Password
+	switch mode {
+	case telnetAuthPassword:
+		username = ""
+	case telnetAuthNoPassword:
+		password = ""
+	}
+	info.Report(&core.ScanResult{
+		Service:  "telnet",
+		Username: username,
+		Password: password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: metadata,
+	})
 	return nil
 }
 
@@ -113,241 +169,291 @@ type TelnetClient struct {
 	timeout  time.Duration
 	username string
 	password string
+	profiles []*telnetProfile
+	profile  *telnetProfile // 识别出的设备配置
+	output   string         // 最近一个阶段的输出，用于判断当前提示符
 }
 
-// Authenticate 执行Telnet认证
-func (t *TelnetClient) Authenticate() error {
-	// 设置连接超时
-	t.conn.SetDeadline(time.Now().Add(t.timeout))
+// telnetTarget 单个目标的认证特征，在一次扫描内按 host:port 缓存
+type telnetTarget struct {
+	mu           sync.Mutex
+	noAuth       bool            // 无需认证，已上报
+	passwordOnly bool            // 仅密码认证，用户名无关
+	tried        map[string]bool // 仅密码设备已尝试过的密码
+	noPassword   map[string]bool // 无需密码的账户，已上报
+}
 
-	// 处理初始Telnet协商
-	if err := t.handleTelnetNegotiation(); err != nil {
-		return fmt.Errorf("telnet negotiation failed: %v", err)
+func loadTelnetTarget(info *core.HostInfo) *telnetTarget {
+	key := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	value, _ := info.Cache("telnet").LoadOrStore(key, &telnetTarget{tried: make(map[string]bool), noPassword: make(map[string]bool)})
+	return value.(*telnetTarget)
+}
+
+// skip 判断本次尝试是否多余：无需认证的目标和无密码账户已上报，仅密码设备的同一密码无需对不同用户名重复尝试
+func (t *telnetTarget) skip(username, password string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.noAuth {
+		return true, nil
+	}
+	if t.noPassword[username] {
+		return true, fmt.Errorf("telnet account %s requires no password, already reported", username)
 	}
+	if t.passwordOnly && t.tried[password] {
+		return true, fmt.Errorf("telnet password-only device, password already tried")
+	}
+	return false, nil
+}
 
-	// 等待登录提示
-	if err := t.waitForLoginPrompt(); err != nil {
-		return fmt.Errorf("failed to get login prompt: %v", err)
+// record 记录识别出的认证方式
+func (t *telnetTarget) record(mode, username, password string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch mode {
+	case telnetAuthNone:
+		t.noAuth = true
+	case telnetAuthNoPassword:
+		t.noPassword[username] = true
+	case telnetAuthPassword:
+		t.passwordOnly = true
+		t.tried[password] = true
 	}
+}
 
-	// 发送用户名
-	if err := t.sendUsername(); err != nil {
-		return fmt.Errorf("failed to send username: %v", err)
+// Authenticate 执行Telnet认证，返回识别出的认证方式（出错时也可能已确定）
+func (t *TelnetClient) Authenticate() (string, error) {
+	// 设置连接超时
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+
+	// 等待登录提示，设备可能只要求密码或直接进入命令行
+	kind, err := t.waitForLoginPrompt()
+	if err != nil {
+		return "", fmt.Errorf("failed to get login prompt: %v", err)
 	}
 
-	// 等待密码提示
-	if err := t.waitForPasswordPrompt(); err != nil {
-		return fmt.Errorf("failed to get password prompt: %v", err)
+	mode := telnetAuthLogin
+	switch kind {
+	case telnetPromptShell:
+		return telnetAuthNone, nil
+
+	case telnetPromptPassword:
+		// 仅密码认证，跳过用户名
+		mode = telnetAuthPassword
+
+	default:
+		// 发送用户名
+		if err := t.sendUsername(); err != nil {
+			return mode, fmt.Errorf("failed to send username: %v", err)
+		}
+
+		// 等待密码提示，无密码账户会直接进入命令行，此时未验证任何密码
+		kind, err := t.waitForPasswordPrompt()
+		if err != nil {
+			return mode, fmt.Errorf("failed to get password prompt: %v", err)
+		}
+		if kind == telnetPromptShell {
+			return telnetAuthNoPassword, nil
+		}
 	}
 
 	// 发送密码
 	if err := t.sendPassword(); err != nil {
-		return fmt.Errorf("failed to send password: %v", err)
+		return mode, fmt.Errorf("failed to send password: %v", err)
 	}
 
 	// 验证登录是否成功
 	if err := t.verifyLogin(); err != nil {
-		return fmt.Errorf("login verification failed: %v", err)
+		return mode, fmt.Errorf("login verification failed: %v", err)
 	}
 
-	return nil
+	return mode, nil
 }
 
-// handleTelnetNegotiation 处理Telnet协议协商
-func (t *TelnetClient) handleTelnetNegotiation() error {
-	// 读取并处理初始的Telnet协商命令
-	for i := 0; i < 10; i++ { // 最多处理10轮协商
-		// 检查context
-		select {
-		case <-t.ctx.Done():
-			return t.ctx.Err()
-		default:
-		}
+// Enable 在用户模式下依次尝试特权密码（如 Cisco enable），返回成功的密码及是否进入特权模式
+func (t *TelnetClient) Enable(secrets []string) (string, bool) {
+	if t.profile.PrivilegedPrompt(t.output) {
+		return "", true
+	}
+	if t.profile.Enable == "" || len(secrets) == 0 {
+		return "", false
+	}
 
-		// 设置短超时读取
-		t.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-		byte1, err := t.reader.ReadByte()
-		if err != nil {
-			// 如果是超时，可能协商已完成
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				break
+	atPrompt := false
+	for _, secret := range secrets {
+		if !atPrompt {
+			if err := t.sendLine(t.profile.Enable); err != nil {
+				return "", false
+			}
+			output, err := t.expect(5*time.Second, func(output string) bool {
+				return t.profile.PrivilegedPrompt(output) || t.promptKind(output) == telnetPromptPassword ||
+					t.profile.Failed(output) || t.profile.ShellPrompt(output)
+			})
+			if err != nil {
+				return "", false
+			}
+			// 未设置特权密码时直接进入特权模式
+			if t.profile.PrivilegedPrompt(output) {
+				t.output = output
+				return "", true
+			}
+			if t.promptKind(output) != telnetPromptPassword {
+				return "", false
 			}
-			return err
-		}
-
-		// 如果不是IAC命令，可能是登录提示的开始
-		if byte1 != TELNET_IAC {
-			// 将字节放回缓冲区
-			t.reader = bufio.NewReader(io.MultiReader(bytes.NewReader([]byte{byte1}), t.reader))
-			break
 		}
 
-		// 读取命令和选项
-		cmd, err := t.reader.ReadByte()
-		if err != nil {
-			return err
+		if err := t.sendLine(secret); err != nil {
+			return "", false
 		}
-
-		option, err := t.reader.ReadByte()
+		output, err := t.expect(5*time.Second, func(output string) bool {
+			return t.profile.PrivilegedPrompt(output) || t.promptKind(output) == telnetPromptPassword ||
+				t.profile.Failed(output) || t.profile.ShellPrompt(output)
+		})
 		if err != nil {
-			return err
+			return "", false
 		}
-
-		// 处理Telnet命令
-		if err := t.handleTelnetCommand(cmd, option); err != nil {
-			return err
+		if t.profile.PrivilegedPrompt(output) {
+			t.output = output
+			return secret, true
 		}
+		// Cisco 在同一次 enable 中允许重试，重新出现密码提示时直接发送下一个密码
+		atPrompt = t.promptKind(output) == telnetPromptPassword
 	}
-
-	// 重置读取超时
-	t.conn.SetReadDeadline(time.Now().Add(t.timeout))
-	return nil
+	return "", false
 }
 
-// handleTelnetCommand 处理Telnet命令
-func (t *TelnetClient) handleTelnetCommand(cmd, option byte) error {
-	switch cmd {
-	case TELNET_DO:
-		// 服务器要求我们启用某个选项，我们拒绝
-		return t.sendTelnetResponse(TELNET_WONT, option)
-	case TELNET_DONT:
-		// 服务器要求我们不要使用某个选项，我们同意
-		return nil
-	case TELNET_WILL:
-		// 服务器将启用某个选项
-		if option == TELNET_ECHO || option == TELNET_SUPPRESS_GO_AHEAD {
-			// 同意服务器启用回显和抑制前进
-			return t.sendTelnetResponse(TELNET_DO, option)
-		} else {
-			// 其他选项我们不需要
-			return t.sendTelnetResponse(TELNET_DONT, option)
-		}
-	case TELNET_WONT:
-		// 服务器不会启用某个选项
-		return nil
-	case TELNET_SB:
-		// 子协商，读取到SE为止
-		return t.skipSubnegotiation()
-	}
-	return nil
-}
+// telnetPromptTimeout 等待登录提示的时间，部分设备输出较长的 banner
+const telnetPromptTimeout = 15 * time.Second
 
-// sendTelnetResponse 发送Telnet响应
-func (t *TelnetClient) sendTelnetResponse(cmd, option byte) error {
-	response := []byte{TELNET_IAC, cmd, option}
-	_, err := t.conn.Write(response)
-	return err
-}
+// telnetSettleTime 匹配到提示后等待的静默时间，确认提示位于输出末尾
+const telnetSettleTime = 300 * time.Millisecond
 
-// skipSubnegotiation 跳过子协商
-func (t *TelnetClient) skipSubnegotiation() error {
-	for {
-		byte1, err := t.reader.ReadByte()
-		if err != nil {
-			return err
-		}
-		if byte1 == TELNET_IAC {
-			byte2, err := t.reader.ReadByte()
-			if err != nil {
-				return err
-			}
-			if byte2 == TELNET_SE {
-				break
-			}
-		}
-	}
-	return nil
-}
+// telnetMaxOutput 单个阶段保留的最大输出
+const telnetMaxOutput = 8192
 
-// waitForLoginPrompt 等待登录提示
-func (t *TelnetClient) waitForLoginPrompt() error {
-	promptPatterns := []string{
-		"login:",
-		"username:",
-		"user:",
-		"账号:",
-		"用户名:",
-		"登录:",
-	}
+// 提示类型
+const (
+	telnetPromptLogin    = "login"
+	telnetPromptPassword = "password"
+	telnetPromptShell    = "shell"
+)
 
-	return t.waitForPrompt(promptPatterns, 15*time.Second)
-}
+// Telnet 认证方式
+const (
+	telnetAuthLogin      = "login"      // 用户名 + 密码
+	telnetAuthPassword   = "password"   // 仅密码
+	telnetAuthNoPassword = "nopassword" // 用户名无需密码直接进入命令行
+	telnetAuthNone       = "none"       // 无需认证直接进入命令行
+)
 
-// waitForPasswordPrompt 等待密码提示
-func (t *TelnetClient) waitForPasswordPrompt() error {
-	promptPatterns := []string{
-		"password:",
-		"passwd:",
-		"密码:",
-		"口令:",
+// promptKind 判断输出末行的提示类型，优先使用已选配置，其次为任一配置
+func (t *TelnetClient) promptKind(output string) string {
+	for _, profile := range append([]*telnetProfile{t.profile}, t.profiles...) {
+		if profile == nil {
+			continue
+		}
+		if profile.PasswordPrompt(output) {
+			return telnetPromptPassword
+		}
+		if profile.LoginPrompt(output) {
+			return telnetPromptLogin
+		}
 	}
-
-	return t.waitForPrompt(promptPatterns, 10*time.Second)
+	return ""
 }
 
-// waitForPrompt 等待指定的提示符
-func (t *TelnetClient) waitForPrompt(patterns []string, timeout time.Duration) error {
-	// 设置读取超时
-	t.conn.SetReadDeadline(time.Now().Add(timeout))
+// expect 读取输出直到 done 返回 true 且随后短时间内没有新数据，返回本阶段的输出
+func (t *TelnetClient) expect(timeout time.Duration, done func(output string) bool) (string, error) {
+	deadline := time.Now().Add(timeout)
 	defer t.conn.SetReadDeadline(time.Now().Add(t.timeout))
 
-	buffer := make([]byte, 0, 1024)
+	var output []byte
+	chunk := make([]byte, 1024)
+	matched := false
 	for {
 		// 检查context
 		select {
 		case <-t.ctx.Done():
-			return t.ctx.Err()
+			return string(output), t.ctx.Err()
 		default:
 		}
 
-		// 读取一个字节
-		b, err := t.reader.ReadByte()
-		if err != nil {
-			return err
+		readDeadline := deadline
+		if matched {
+			readDeadline = time.Now().Add(telnetSettleTime)
 		}
-
-		buffer = append(buffer, b)
-
-		// 保持缓冲区大小合理
-		if len(buffer) > 2048 {
-			buffer = buffer[1024:] // 保留后半部分
+		t.conn.SetReadDeadline(readDeadline)
+
+		n, err := t.reader.Read(chunk)
+		if n > 0 {
+			output = append(output, chunk[:n]...)
+			// 保持缓冲区大小合理
+			if len(output) > telnetMaxOutput {
+				output = output[len(output)-telnetMaxOutput/2:]
+			}
+			matched = done(string(output))
+			continue
 		}
-
-		// 转换为小写字符串进行匹配
-		text := strings.ToLower(string(buffer))
-
-		// 检查是否匹配任何提示符模式
-		for _, pattern := range patterns {
-			if strings.Contains(text, strings.ToLower(pattern)) {
-				return nil
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if matched {
+					return string(output), nil
+				}
+				return string(output), fmt.Errorf("timeout waiting for prompt")
 			}
+			return string(output), err
 		}
+	}
+}
 
-		// 如果缓冲区包含错误信息，提前返回
-		if strings.Contains(text, "connection refused") ||
-			strings.Contains(text, "connection closed") ||
-			strings.Contains(text, "access denied") {
-			return fmt.Errorf("connection error detected")
-		}
+// waitForLoginPrompt 等待登录提示或命令提示符，并根据登录前的输出选择设备配置
+func (t *TelnetClient) waitForLoginPrompt() (string, error) {
+	output, err := t.expect(telnetPromptTimeout, func(output string) bool {
+		return anyTelnetPrompt(t.profiles, output) || anyTelnetShell(t.profiles, output)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if t.profile == nil {
+		t.profile = selectTelnetProfile(t.profiles, output)
+	}
+	t.output = output
+	if kind := t.promptKind(output); kind != "" {
+		return kind, nil
 	}
+	return telnetPromptShell, nil
 }
 
-// sendUsername 发送用户名
-func (t *TelnetClient) sendUsername() error {
-	// 检查context
-	select {
-	case <-t.ctx.Done():
-		return t.ctx.Err()
-	default:
+// waitForPasswordPrompt 等待密码提示，无密码账户可能直接出现命令提示符
+func (t *TelnetClient) waitForPasswordPrompt() (string, error) {
+	output, err := t.expect(10*time.Second, func(output string) bool {
+		return t.profile.Failed(output) || t.promptKind(output) == telnetPromptPassword || t.profile.ShellPrompt(output)
+	})
+	if err != nil {
+		return "", err
 	}
+	if t.profile.Failed(output) {
+		return "", fmt.Errorf("login rejected before password")
+	}
+	t.output = output
+	if t.promptKind(output) == telnetPromptPassword {
+		return telnetPromptPassword, nil
+	}
+	return telnetPromptShell, nil
+}
 
-	data := t.username + "\r\n"
-	_, err := t.conn.Write([]byte(data))
-	return err
+// sendUsername 发送用户名
+func (t *TelnetClient) sendUsername() error {
+	return t.sendLine(t.username)
 }
 
 // sendPassword 发送密码
 func (t *TelnetClient) sendPassword() error {
+	return t.sendLine(t.password)
+}
+
+// sendLine 发送一行输入
+func (t *TelnetClient) sendLine(line string) error {
 	// 检查context
 	select {
 	case <-t.ctx.Done():
@@ -355,115 +461,29 @@ func (t *TelnetClient) sendPassword() error {
 	default:
 	}
 
-	data := t.password + "\r\n"
-	_, err := t.conn.Write([]byte(data))
+	_, err := t.conn.Write([]byte(line + "\r\n"))
 	return err
 }
 
-// verifyLogin 验证登录是否成功
+// verifyLogin 验证登录是否成功：出现失败信息或再次出现登录提示为失败，末行为命令提示符为成功
 func (t *TelnetClient) verifyLogin() error {
-	// 设置读取超时
-	t.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-	defer t.conn.SetReadDeadline(time.Now().Add(t.timeout))
-
-	buffer := make([]byte, 0, 2048)
-	startTime := time.Now()
-
-	for time.Since(startTime) < 8*time.Second {
-		// 检查context
-		select {
-		case <-t.ctx.Done():
-			return t.ctx.Err()
-		default:
-		}
-
-		// 尝试读取数据
-		t.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		b, err := t.reader.ReadByte()
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				// 超时，继续尝试
-				continue
-			}
-			return err
-		}
-
-		buffer = append(buffer, b)
-
-		// 保持缓冲区大小合理
-		if len(buffer) > 4096 {
-			buffer = buffer[2048:] // 保留后半部分
-		}
-
-		// 转换为小写字符串进行分析
-		text := strings.ToLower(string(buffer))
-
-		// 检查登录失败的标志
-		failurePatterns := []string{
-			"login incorrect",
-			"login failed",
-			"authentication failed",
-			"access denied",
-			"invalid",
-			"wrong",
-			"error",
-			"failed",
-			"incorrect",
-			"denied",
-			"登录失败",
-			"认证失败",
-			"用户名或密码错误",
-			"访问被拒绝",
-		}
-
-		for _, pattern := range failurePatterns {
-			if strings.Contains(text, pattern) {
-				return fmt.Errorf("authentication failed: %s", pattern)
-			}
-		}
-
-		// 检查登录成功的标志
-		successPatterns := []string{
-			"$", // Shell提示符
-			"#", // Root提示符
-			">", // Windows命令提示符
-			"welcome",
-			"last login",
-			"successful",
-			"欢迎",
-			"成功",
-		}
-
-		// 检查是否包含成功标志，并且没有再次出现登录提示
-		hasSuccessPattern := false
-		for _, pattern := range successPatterns {
-			if strings.Contains(text, pattern) {
-				hasSuccessPattern = true
-				break
-			}
-		}
-
-		// 如果有成功标志且没有再次出现登录提示，认为登录成功
-		if hasSuccessPattern && !strings.Contains(text, "login:") && !strings.Contains(text, "username:") {
-			return nil
-		}
-
-		// 如果缓冲区足够大且包含提示符，可能登录成功
-		if len(buffer) > 100 {
-			// 检查最后几个字符是否像提示符
-			lastChars := string(buffer[len(buffer)-10:])
-			if strings.Contains(lastChars, "$") || strings.Contains(lastChars, "#") || strings.Contains(lastChars, ">") {
-				return nil
-			}
-		}
+	output, err := t.expect(10*time.Second, func(output string) bool {
+		return t.profile.Failed(output) || t.promptKind(output) != "" || t.profile.ShellPrompt(output)
+	})
+	if err != nil {
+		return fmt.Errorf("no shell prompt after login: %v", err)
 	}
 
-	// 如果没有明确的失败信息，且读取到了数据，可能是成功的
-	if len(buffer) > 50 {
+	switch {
+	case t.profile.Failed(output):
+		return fmt.Errorf("authentication failed")
+	case t.promptKind(output) != "":
+		return fmt.Errorf("authentication failed: prompted again")
+	case t.profile.ShellPrompt(output):
+		t.output = output
 		return nil
 	}
-
-	return fmt.Errorf("login verification timeout or failed")
+	return fmt.Errorf("no shell prompt after login")
 }
 
 // 注册插件
@@ -0,0 +1,247 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+// TERMINAL-TYPE 子协商命令（RFC 1091）
+const (
+	telnetTTypeIs   = 0
+	telnetTTypeSend = 1
+)
+
+// 终端参数
+const (
+	telnetTerminalType = "VT100"
+	telnetWindowWidth  = 80
+	telnetWindowHeight = 24
+)
+
+// telnetConn 解析状态
+const (
+	telnetStateData   = iota
+	telnetStateIAC    // 收到 IAC
+	telnetStateOption // 收到 IAC WILL/WONT/DO/DONT，等待选项
+	telnetStateSB     // 子协商数据
+	telnetStateSBIAC  // 子协商中收到 IAC
+	telnetStateCR     // 收到 CR，丢弃随后的 NUL（RFC 854）
+)
+
+// telnetLocalOptions 客户端愿意启用的选项（响应 DO）
+var telnetLocalOptions = map[byte]bool{
+	TELNET_TERMINAL_TYPE:     true,
+	TELNET_WINDOW_SIZE:       true,
+	TELNET_SUPPRESS_GO_AHEAD: true,
+}
+
+// telnetRemoteOptions 允许服务端启用的选项（响应 WILL）
+var telnetRemoteOptions = map[byte]bool{
+	TELNET_ECHO:              true,
+	TELNET_SUPPRESS_GO_AHEAD: true,
+}
+
+// telnetConn 在整个会话中从数据流里过滤 Telnet 命令并应答选项协商（RFC 854/1091/1073）
+// 每个选项只记录启用状态，仅在状态变化时应答，避免协商循环
+type telnetConn struct {
+	net.Conn
+
+	state   int
+	command byte   // 当前的 WILL/WONT/DO/DONT
+	sb      []byte // 子协商内容（不含 IAC SB / IAC SE）
+	local   map[byte]bool
+	remote  map[byte]bool
+	refused map[[2]byte]bool // 已拒绝过的（应答命令, 选项），本端和对端两个方向分别记录
+	raw     []byte
+}
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	return &telnetConn{
+		Conn:    conn,
+		local:   make(map[byte]bool),
+		remote:  make(map[byte]bool),
+		refused: make(map[[2]byte]bool),
+		raw:     make([]byte, 4096),
+	}
+}
+
+// Read 返回去除 Telnet 命令后的数据，读取过程中发送选项应答
+func (c *telnetConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		// 过滤后的数据不会多于原始数据，按 p 的长度读取即可
+		n, err := c.Conn.Read(c.raw[:min(len(p), len(c.raw))])
+		data, reply := c.process(c.raw[:n], p)
+		if len(reply) > 0 {
+			if _, werr := c.Conn.Write(reply); werr != nil && err == nil {
+				err = werr
+			}
+		}
+		// 整块都是协商命令时继续读取，避免向上层返回 0 字节
+		if data > 0 || err != nil {
+			return data, err
+		}
+	}
+}
+
+// Write 发送数据，数据中的 0xFF 转义为 IAC IAC
+func (c *telnetConn) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, TELNET_IAC) < 0 {
+		return c.Conn.Write(p)
+	}
+	escaped := bytes.ReplaceAll(p, []byte{TELNET_IAC}, []byte{TELNET_IAC, TELNET_IAC})
+	if _, err := c.Conn.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// process 处理一段原始数据，将普通数据写入 out（长度不小于 in），返回数据长度及需要发送的应答
+func (c *telnetConn) process(in, out []byte) (int, []byte) {
+	var reply []byte
+	n := 0
+	for _, b := range in {
+		switch c.state {
+		case telnetStateData, telnetStateCR:
+			if c.state == telnetStateCR {
+				c.state = telnetStateData
+				if b == 0 {
+					continue
+				}
+			}
+			switch b {
+			case TELNET_IAC:
+				c.state = telnetStateIAC
+			case '\r':
+				c.state = telnetStateCR
+				out[n] = b
+				n++
+			default:
+				out[n] = b
+				n++
+			}
+
+		case telnetStateIAC:
+			switch b {
+			case TELNET_IAC:
+				// 转义的 0xFF 数据
+				out[n] = b
+				n++
+				c.state = telnetStateData
+			case TELNET_WILL, TELNET_WONT, TELNET_DO, TELNET_DONT:
+				c.command = b
+				c.state = telnetStateOption
+			case TELNET_SB:
+				c.sb = c.sb[:0]
+				c.state = telnetStateSB
+			default:
+				// NOP、GA、DM 等无需处理
+				c.state = telnetStateData
+			}
+
+		case telnetStateOption:
+			reply = append(reply, c.negotiate(c.command, b)...)
+			c.state = telnetStateData
+
+		case telnetStateSB:
+			if b == TELNET_IAC {
+				c.state = telnetStateSBIAC
+			} else {
+				c.sb = append(c.sb, b)
+			}
+
+		case telnetStateSBIAC:
+			switch b {
+			case TELNET_SE:
+				reply = append(reply, c.subnegotiate(c.sb)...)
+				c.state = telnetStateData
+			case TELNET_IAC:
+				c.sb = append(c.sb, b)
+				c.state = telnetStateSB
+			default:
+				// 不完整的子协商，按 RFC 854 丢弃
+				c.state = telnetStateData
+			}
+		}
+	}
+	return n, reply
+}
+
+// negotiate 处理选项协商命令，返回应答
+func (c *telnetConn) negotiate(command, option byte) []byte {
+	switch command {
+	case TELNET_DO:
+		if c.local[option] {
+			return nil
+		}
+		if !telnetLocalOptions[option] {
+			return c.refuse(TELNET_WONT, option)
+		}
+		c.local[option] = true
+		reply := []byte{TELNET_IAC, TELNET_WILL, option}
+		// 同意 NAWS 后立即发送窗口大小（RFC 1073）
+		if option == TELNET_WINDOW_SIZE {
+			reply = append(reply, c.windowSize()...)
+		}
+		return reply
+
+	case TELNET_DONT:
+		if !c.local[option] {
+			return nil
+		}
+		c.local[option] = false
+		return []byte{TELNET_IAC, TELNET_WONT, option}
+
+	case TELNET_WILL:
+		if c.remote[option] {
+			return nil
+		}
+		if !telnetRemoteOptions[option] {
+			return c.refuse(TELNET_DONT, option)
+		}
+		c.remote[option] = true
+		return []byte{TELNET_IAC, TELNET_DO, option}
+
+	case TELNET_WONT:
+		if !c.remote[option] {
+			return nil
+		}
+		c.remote[option] = false
+		return []byte{TELNET_IAC, TELNET_DONT, option}
+	}
+	return nil
+}
+
+// refuse 拒绝选项，同一方向的同一选项只应答一次（WONT 应答 DO，DONT 应答 WILL）
+func (c *telnetConn) refuse(command, option byte) []byte {
+	key := [2]byte{command, option}
+	if c.refused[key] {
+		return nil
+	}
+	c.refused[key] = true
+	return []byte{TELNET_IAC, command, option}
+}
+
+// subnegotiate 处理子协商，目前只应答 TERMINAL-TYPE SEND
+func (c *telnetConn) subnegotiate(sb []byte) []byte {
+	if len(sb) >= 2 && sb[0] == TELNET_TERMINAL_TYPE && sb[1] == telnetTTypeSend && c.local[TELNET_TERMINAL_TYPE] {
+		reply := []byte{TELNET_IAC, TELNET_SB, TELNET_TERMINAL_TYPE, telnetTTypeIs}
+		reply = append(reply, telnetTerminalType...)
+		return append(reply, TELNET_IAC, TELNET_SE)
+	}
+	return nil
+}
+
+// windowSize 构造 NAWS 子协商，参数中的 0xFF 需要转义
+func (c *telnetConn) windowSize() []byte {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint16(size[0:2], telnetWindowWidth)
+	binary.BigEndian.PutUint16(size[2:4], telnetWindowHeight)
+
+	reply := []byte{TELNET_IAC, TELNET_SB, TELNET_WINDOW_SIZE}
+	reply = append(reply, bytes.ReplaceAll(size, []byte{TELNET_IAC}, []byte{TELNET_IAC, TELNET_IAC})...)
+	return append(reply, TELNET_IAC, TELNET_SE)
+}
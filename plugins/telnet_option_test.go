@@ -0,0 +1,165 @@
+package plugins
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+// transcriptConn 按录制的数据块依次返回服务端输出，并记录客户端发送的内容
+type transcriptConn struct {
+	net.Conn
+	chunks  [][]byte
+	written bytes.Buffer
+}
+
+func (c *transcriptConn) Read(p []byte) (int, error) {
+	for len(c.chunks) > 0 && len(c.chunks[0]) == 0 {
+		c.chunks = c.chunks[1:]
+	}
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	return n, nil
+}
+
+func (c *transcriptConn) Write(p []byte) (int, error) {
+	return c.written.Write(p)
+}
+
+func iac(b ...byte) []byte {
+	return append([]byte{TELNET_IAC}, b...)
+}
+
+func joinBytes(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+var nawsReply = joinBytes(iac(TELNET_WILL, TELNET_WINDOW_SIZE), iac(TELNET_SB, TELNET_WINDOW_SIZE, 0, 80, 0, 24), iac(TELNET_SE))
+
+var telnetTranscripts = []struct {
+	name   string
+	chunks [][]byte
+	data   string
+	reply  []byte
+}{
+	{
+		// Linux netkit telnetd：先协商终端类型，再开启回显
+		name: "linux-telnetd",
+		chunks: [][]byte{
+			joinBytes(iac(TELNET_DO, TELNET_TERMINAL_TYPE), iac(TELNET_DO, TELNET_TERMINAL_SPEED), iac(TELNET_DO, 35), iac(TELNET_DO, 39)),
+			joinBytes(iac(TELNET_SB, TELNET_TERMINAL_TYPE, telnetTTypeSend), iac(TELNET_SE)),
+			joinBytes(iac(TELNET_WILL, TELNET_SUPPRESS_GO_AHEAD), iac(TELNET_DO, TELNET_ECHO), iac(TELNET_DO, TELNET_WINDOW_SIZE),
+				iac(TELNET_WILL, 5), iac(TELNET_DO, TELNET_REMOTE_FLOW_CONTROL)),
+			joinBytes(iac(TELNET_WILL, TELNET_ECHO), []byte("Ubuntu 22.04.3 LTS\r\nhost login: ")),
+		},
+		data: "Ubuntu 22.04.3 LTS\r\nhost login: ",
+		reply: joinBytes(
+			iac(TELNET_WILL, TELNET_TERMINAL_TYPE), iac(TELNET_WONT, TELNET_TERMINAL_SPEED), iac(TELNET_WONT, 35), iac(TELNET_WONT, 39),
+			iac(TELNET_SB, TELNET_TERMINAL_TYPE, telnetTTypeIs), []byte(telnetTerminalType), iac(TELNET_SE),
+			iac(TELNET_DO, TELNET_SUPPRESS_GO_AHEAD), iac(TELNET_WONT, TELNET_ECHO), nawsReply,
+			iac(TELNET_DONT, 5), iac(TELNET_WONT, TELNET_REMOTE_FLOW_CONTROL),
+			iac(TELNET_DO, TELNET_ECHO),
+		),
+	},
+	{
+		// Cisco IOS：协商与 banner 在同一个数据包中
+		name: "cisco-ios",
+		chunks: [][]byte{
+			joinBytes(iac(TELNET_WILL, TELNET_ECHO), iac(TELNET_WILL, TELNET_SUPPRESS_GO_AHEAD), iac(TELNET_DO, TELNET_TERMINAL_TYPE),
+				iac(TELNET_DO, TELNET_WINDOW_SIZE), []byte("\r\n\r\nUser Access Verification\r\n\r\nUsername: ")),
+		},
+		data: "\r\n\r\nUser Access Verification\r\n\r\nUsername: ",
+		reply: joinBytes(
+			iac(TELNET_DO, TELNET_ECHO), iac(TELNET_DO, TELNET_SUPPRESS_GO_AHEAD), iac(TELNET_WILL, TELNET_TERMINAL_TYPE), nawsReply,
+		),
+	},
+	{
+		// 华为 VRP：会话中途出现的协商、NOP、转义的 0xFF 和 CR NUL
+		name: "huawei-vrp",
+		chunks: [][]byte{
+			joinBytes(iac(TELNET_WILL, TELNET_ECHO), iac(TELNET_WILL, TELNET_SUPPRESS_GO_AHEAD), []byte("Login authentication\r\n\r\nUsername:")),
+			joinBytes([]byte("\r\x00\nInfo: "), iac(241), []byte("data "), iac(TELNET_IAC), []byte(" end\r\n")),
+			joinBytes(iac(TELNET_DO, TELNET_WINDOW_SIZE), iac(TELNET_DO, TELNET_TERMINAL_TYPE), iac(TELNET_DO, TELNET_TERMINAL_TYPE),
+				iac(TELNET_WILL, TELNET_ECHO), iac(TELNET_DONT, TELNET_WINDOW_SIZE), []byte("<Huawei>")),
+		},
+		data: "Login authentication\r\n\r\nUsername:\r\nInfo: data \xff end\r\n<Huawei>",
+		reply: joinBytes(
+			iac(TELNET_DO, TELNET_ECHO), iac(TELNET_DO, TELNET_SUPPRESS_GO_AHEAD),
+			nawsReply, iac(TELNET_WILL, TELNET_TERMINAL_TYPE), iac(TELNET_WONT, TELNET_WINDOW_SIZE),
+		),
+	},
+	{
+		// 同一选项在两个方向上分别被拒绝：DO 应答 WONT 之后的 WILL 仍需应答 DONT，重复请求不再应答
+		name: "refuse-both-directions",
+		chunks: [][]byte{
+			joinBytes(iac(TELNET_DO, 5), []byte("login: ")),
+			joinBytes(iac(TELNET_WILL, 5), iac(TELNET_DO, 5), iac(TELNET_WILL, 5)),
+		},
+		data:  "login: ",
+		reply: joinBytes(iac(TELNET_WONT, 5), iac(TELNET_DONT, 5)),
+	},
+}
+
+func TestTelnetConnTranscripts(t *testing.T) {
+	for _, tt := range telnetTranscripts {
+		readers := map[string]func(io.Reader) io.Reader{
+			"packets":  func(r io.Reader) io.Reader { return r },
+			"one-byte": iotest.OneByteReader,
+		}
+		for mode, wrap := range readers {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				raw := &transcriptConn{}
+				for _, chunk := range tt.chunks {
+					raw.chunks = append(raw.chunks, append([]byte(nil), chunk...))
+				}
+				conn := newTelnetConn(raw)
+
+				data, err := io.ReadAll(wrap(conn))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != tt.data {
+					t.Errorf("data = %q, want %q", data, tt.data)
+				}
+				if !bytes.Equal(raw.written.Bytes(), tt.reply) {
+					t.Errorf("reply = % x, want % x", raw.written.Bytes(), tt.reply)
+				}
+			})
+		}
+	}
+}
+
+// 协商命令被拆分到多个 TCP 包时应保持状态
+func TestTelnetConnSplitSequences(t *testing.T) {
+	stream := joinBytes(iac(TELNET_DO, TELNET_TERMINAL_TYPE), iac(TELNET_SB, TELNET_TERMINAL_TYPE, telnetTTypeSend), iac(TELNET_SE), []byte("login: "))
+	for split := 1; split < len(stream); split++ {
+		raw := &transcriptConn{chunks: [][]byte{append([]byte(nil), stream[:split]...), append([]byte(nil), stream[split:]...)}}
+		data, err := io.ReadAll(newTelnetConn(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "login: " {
+			t.Errorf("split %d: data = %q", split, data)
+		}
+		want := joinBytes(iac(TELNET_WILL, TELNET_TERMINAL_TYPE), iac(TELNET_SB, TELNET_TERMINAL_TYPE, telnetTTypeIs), []byte(telnetTerminalType), iac(TELNET_SE))
+		if !bytes.Equal(raw.written.Bytes(), want) {
+			t.Errorf("split %d: reply = % x", split, raw.written.Bytes())
+		}
+	}
+}
+
+func TestTelnetConnWriteEscapesIAC(t *testing.T) {
+	raw := &transcriptConn{}
+	n, err := newTelnetConn(raw).Write([]byte("p\xffss"))
+	if err != nil || n != 4 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if got := raw.written.String(); got != "p\xff\xffss" {
+		t.Errorf("written = %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package plugins
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed telnet_profiles.yaml
+var defaultTelnetProfiles []byte
+
+// telnetProfile 设备族的登录提示、失败和成功特征
+type telnetProfile struct {
+	Name     string   `yaml:"name"`
+	Banner   []string `yaml:"banner"`
+	Login    []string `yaml:"login"`
+	Password []string `yaml:"password"`
+	Failure  []string `yaml:"failure"`
+	Success  []string `yaml:"success"`
+
+	// 可选的特权模式：进入命令及特权提示符（如 Cisco enable / Router#）
+	Enable     string   `yaml:"enable"`
+	Privileged []string `yaml:"privileged"`
+
+	banner     []*regexp.Regexp
+	login      []*regexp.Regexp
+	password   []*regexp.Regexp
+	failure    []*regexp.Regexp
+	success    []*regexp.Regexp
+	privileged []*regexp.Regexp
+}
+
+// telnetProfileSet 按文件路径缓存已编译的配置，空路径为内置配置
+var telnetProfileSet sync.Map
+
+type telnetProfileEntry struct {
+	once     sync.Once
+	profiles []*telnetProfile
+	err      error
+}
+
+// loadTelnetProfiles 加载并编译设备配置，path 为空时使用内置配置
+func loadTelnetProfiles(path string) ([]*telnetProfile, error) {
+	value, _ := telnetProfileSet.LoadOrStore(path, &telnetProfileEntry{})
+	entry := value.(*telnetProfileEntry)
+
+	entry.once.Do(func() {
+		data := defaultTelnetProfiles
+		if path != "" {
+			if data, entry.err = os.ReadFile(path); entry.err != nil {
+				return
+			}
+		}
+		entry.profiles, entry.err = parseTelnetProfiles(data)
+	})
+	return entry.profiles, entry.err
+}
+
+// parseTelnetProfiles 解析 YAML 配置并编译其中的正则表达式
+func parseTelnetProfiles(data []byte) ([]*telnetProfile, error) {
+	var config struct {
+		Profiles []*telnetProfile `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("telnet profiles: %v", err)
+	}
+	if len(config.Profiles) == 0 {
+		return nil, fmt.Errorf("telnet profiles: no profile defined")
+	}
+
+	for _, profile := range config.Profiles {
+		for _, field := range []struct {
+			patterns []string
+			target   *[]*regexp.Regexp
+		}{
+			{profile.Banner, &profile.banner},
+			{profile.Login, &profile.login},
+			{profile.Password, &profile.password},
+			{profile.Failure, &profile.failure},
+			{profile.Success, &profile.success},
+			{profile.Privileged, &profile.privileged},
+		} {
+			for _, pattern := range field.patterns {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("telnet profile %s: %v", profile.Name, err)
+				}
+				*field.target = append(*field.target, re)
+			}
+		}
+	}
+	return config.Profiles, nil
+}
+
+// selectTelnetProfile 优先按 banner 识别设备族，其次取第一个用户名提示匹配的配置，最后使用末尾的兜底配置
+// 密码提示在各设备族间几乎相同，不用于识别
+func selectTelnetProfile(profiles []*telnetProfile, output string) *telnetProfile {
+	for _, profile := range profiles {
+		if matchAny(profile.banner, output) {
+			return profile
+		}
+	}
+
+	tail := lastLine(output)
+	for _, profile := range profiles {
+		if matchAny(profile.login, tail) {
+			return profile
+		}
+	}
+	return profiles[len(profiles)-1]
+}
+
+// findTelnetProfile 按名称查找配置
+func findTelnetProfile(profiles []*telnetProfile, name string) *telnetProfile {
+	for _, profile := range profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile
+		}
+	}
+	return nil
+}
+
+// anyTelnetPrompt 任一配置的登录或密码提示出现在输出末行
+func anyTelnetPrompt(profiles []*telnetProfile, output string) bool {
+	tail := lastLine(output)
+	for _, profile := range profiles {
+		if matchAny(profile.login, tail) || matchAny(profile.password, tail) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginPrompt 输出末行是否为用户名提示
+func (p *telnetProfile) LoginPrompt(output string) bool {
+	return matchAny(p.login, lastLine(output))
+}
+
+// PasswordPrompt 输出末行是否为密码提示
+func (p *telnetProfile) PasswordPrompt(output string) bool {
+	return matchAny(p.password, lastLine(output))
+}
+
+// Failed 输出中是否包含登录失败信息
+func (p *telnetProfile) Failed(output string) bool {
+	return matchAny(p.failure, output)
+}
+
+// ShellPrompt 输出末行是否为命令提示符
+func (p *telnetProfile) ShellPrompt(output string) bool {
+	return matchAny(p.success, lastLine(output))
+}
+
+// PrivilegedPrompt 输出末行是否为特权模式提示符
+func (p *telnetProfile) PrivilegedPrompt(output string) bool {
+	return matchAny(p.privileged, lastLine(output))
+}
+
+// anyTelnetShell 任一配置的命令提示符出现在输出末行
+func anyTelnetShell(profiles []*telnetProfile, output string) bool {
+	for _, profile := range profiles {
+		if profile.ShellPrompt(output) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []*regexp.Regexp, text string) bool {
+	for _, re := range patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastLine 返回输出的最后一行（提示符通常不以换行结尾）
+func lastLine(output string) string {
+	output = strings.TrimRight(output, "\x00")
+	if i := strings.LastIndexAny(output, "\r\n"); i >= 0 {
+		return output[i+1:]
+	}
+	return output
+}
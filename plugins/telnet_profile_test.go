@@ -0,0 +1,290 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// scriptConn 按阶段返回服务端输出：每次客户端写入后进入下一阶段，当前阶段读完后返回读超时
+type scriptConn struct {
+	net.Conn
+	stages [][]byte
+	writes int
+}
+
+func (c *scriptConn) Read(p []byte) (int, error) {
+	if len(c.stages) == 0 || len(c.stages[0]) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(p, c.stages[0])
+	c.stages[0] = c.stages[0][n:]
+	return n, nil
+}
+
+func (c *scriptConn) Write(p []byte) (int, error) {
+	c.writes++
+	if len(c.stages) > 0 {
+		c.stages = c.stages[1:]
+	}
+	return len(p), nil
+}
+
+func (c *scriptConn) SetDeadline(time.Time) error     { return nil }
+func (c *scriptConn) SetReadDeadline(time.Time) error { return nil }
+
+// telnetDevices 各设备族的登录前输出、登录成功（含 MOTD）和登录失败的录制输出
+var telnetDevices = []struct {
+	profile  string
+	banner   string // 登录前输出，以用户名提示结尾
+	password string // 提交用户名后的输出
+	success  string
+	failure  string
+}{
+	{
+		profile:  "cisco-ios",
+		banner:   "\r\n\r\nUser Access Verification\r\n\r\nUsername: ",
+		password: "Password: ",
+		success:  "\r\n\r\nRouter>",
+		failure:  "\r\n% Login invalid\r\n\r\nUsername: ",
+	},
+	{
+		profile:  "huawei-vrp",
+		banner:   "\r\n\r\nLogin authentication\r\n\r\n\r\nUsername:",
+		password: "Password:",
+		success: "\r\nInfo: The max number of VTY users is 5, and the number\r\n      of current VTY users on line is 1.\r\n" +
+			"      The current login time is 2024-01-01 00:00:00.\r\n<HUAWEI>",
+		failure: "\r\nError: Authentication failed.\r\n\r\nLogin authentication\r\n\r\n\r\nUsername:",
+	},
+	{
+		profile: "h3c-comware",
+		banner: "\r\n******************************************************************************\r\n" +
+			"* Copyright (c) 2004-2017 New H3C Technologies Co., Ltd. All rights reserved.*\r\n" +
+			"******************************************************************************\r\n\r\nLogin: ",
+		password: "Password: ",
+		success:  "\r\n<H3C>",
+		failure:  "\r\nAAA authentication failed.\r\n\r\nLogin: ",
+	},
+	{
+		profile:  "zte-zxr10",
+		banner:   "\r\n************************************************\r\nWelcome to ZXR10 ZXCTN 6120S of ZTE Corporation\r\n************************************************\r\nUsername:",
+		password: "Password:",
+		success:  "\r\nZXR10>",
+		failure:  "\r\n% Bad password or user name!\r\nUsername:",
+	},
+	{
+		profile:  "linux",
+		banner:   "Ubuntu 22.04.3 LTS\r\nhost login: ",
+		password: "Password: ",
+		success: "\r\nLast login: Mon Jan  1 00:00:00 UTC 2024 from 10.0.0.1 on pts/0\r\n" +
+			"Welcome to Ubuntu 22.04.3 LTS (GNU/Linux 5.15.0-91-generic x86_64)\r\n\r\nroot@host:~# ",
+		failure: "\r\n\r\nLogin incorrect\r\nhost login: ",
+	},
+	{
+		profile:  "busybox",
+		banner:   "\r\nBusyBox v1.19.4 (2014-03-19 10:42:47 CST)\r\n\r\nDVR login: ",
+		password: "Password: ",
+		success:  "\r\n\r\nBusyBox v1.19.4 (2014-03-19 10:42:47 CST) built-in shell (ash)\r\nEnter 'help' for a list of built-in commands.\r\n\r\n# ",
+		failure:  "\r\nLogin incorrect\r\nDVR login: ",
+	},
+	{
+		profile:  "windows",
+		banner:   "Welcome to Microsoft Telnet Service \r\n\r\nlogin: ",
+		password: "password: ",
+		success: "\r\n*===============================================================\r\nMicrosoft Telnet Server.\r\n" +
+			"*===============================================================\r\nC:\\Users\\Administrator>",
+		failure: "\r\nThe handle is invalid.\r\n\r\nlogin: ",
+	},
+	{
+		profile:  "generic",
+		banner:   "\r\nWelcome to NetStorage NAS\r\nUser: ",
+		password: "Password: ",
+		success:  "\r\nnas> ",
+		failure:  "\r\nSystem busy, please try again later >",
+	},
+}
+
+func loadDefaultTelnetProfiles(t *testing.T) []*telnetProfile {
+	profiles, err := loadTelnetProfiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profiles
+}
+
+func TestSelectTelnetProfile(t *testing.T) {
+	profiles := loadDefaultTelnetProfiles(t)
+	for _, device := range telnetDevices {
+		if got := selectTelnetProfile(profiles, device.banner).Name; got != device.profile {
+			t.Errorf("%s: selected profile %s", device.profile, got)
+		}
+	}
+
+	// 只有登录提示、没有可识别 banner 的情况
+	for output, want := range map[string]string{
+		"Username: ":         "cisco-ios",
+		"Login: ":            "h3c-comware",
+		"(none) login: ":     "linux",
+		"\r\nUser Name : ":   "generic",
+		"\r\n用户名：":           "generic",
+		"router12 login:   ": "linux",
+	} {
+		if got := selectTelnetProfile(profiles, output).Name; got != want {
+			t.Errorf("%q: selected profile %s, want %s", output, got, want)
+		}
+	}
+}
+
+// runTelnetLogin 使用录制的输出执行一次完整的 Authenticate
+func runTelnetLogin(profiles []*telnetProfile, stages ...string) (string, error) {
+	mode, _, err := runTelnetScript(profiles, stages...)
+	return mode, err
+}
+
+// runTelnetScript 同 runTelnetLogin，额外返回客户端写入的次数
+func runTelnetScript(profiles []*telnetProfile, stages ...string) (string, int, error) {
+	script := &scriptConn{}
+	for _, stage := range stages {
+		script.stages = append(script.stages, []byte(stage))
+	}
+	client := &TelnetClient{
+		conn:     script,
+		reader:   bufio.NewReader(script),
+		ctx:      context.Background(),
+		timeout:  time.Second,
+		username: "admin",
+		password: "admin",
+		profiles: profiles,
+	}
+	mode, err := client.Authenticate()
+	return mode, script.writes, err
+}
+
+func TestTelnetVerifyLogin(t *testing.T) {
+	profiles := loadDefaultTelnetProfiles(t)
+	for _, device := range telnetDevices {
+		mode, err := runTelnetLogin(profiles, device.banner, device.password, device.success)
+		if err != nil || mode != telnetAuthLogin {
+			t.Errorf("%s: successful login: mode %q, err %v", device.profile, mode, err)
+		}
+
+		if _, err := runTelnetLogin(profiles, device.banner, device.password, device.failure); err == nil {
+			t.Errorf("%s: failed login reported as success", device.profile)
+		}
+	}
+}
+
+func TestTelnetShellPrompt(t *testing.T) {
+	profiles := loadDefaultTelnetProfiles(t)
+	tests := map[string]map[string]bool{
+		"generic": {
+			"nas> ":                                 true,
+			"[root@localhost ~]# ":                  true,
+			"admin@router:~$ ":                      true,
+			"<HUAWEI>":                              true,
+			"C:\\Users\\admin>":                     true,
+			"ONT#":                                  true,
+			"System busy, please try again later >": false,
+			"Press any key to continue>":            false,
+			"Welcome to the system #":               false,
+			"=====>":                                false,
+			"Last login: Mon Jan  1 00:00:00 2024":  false,
+		},
+		"cisco-ios": {
+			"Router>":          true,
+			"Router#":          true,
+			"sw-core(config)#": true,
+			"% Login invalid":  false,
+			"User Access >":    false,
+		},
+		"huawei-vrp": {
+			"<HUAWEI>":       true,
+			"[~HUAWEI]":      true,
+			"Info: <HUAWEI>": false,
+		},
+		"windows": {
+			"C:\\Users\\Administrator>": true,
+			"Microsoft Telnet>":         false,
+		},
+		"linux": {
+			"root@host:~# ":                      true,
+			"[root@localhost ~]# ":               true,
+			"bash-4.2$ ":                         true,
+			"user@macbook ~ % ":                  true,
+			"$ ":                                 true,
+			"Downloading update... 100%":         false,
+			"100%":                               false,
+			"Press # to continue#":               false,
+			"Select an option [1-3] and press #": false,
+			"Total: 512 MB free, usage 42%":      false,
+			"Welcome to Ubuntu 22.04.3 LTS $":    false,
+		},
+		"busybox": {
+			"# ":                   true,
+			"/ # ":                 true,
+			"root@OpenWrt:~# ":     true,
+			"[root@dvr /]# ":       true,
+			"Press # to continue#": false,
+			"Enter option #":       false,
+			"Flashing 3/8 #":       false,
+		},
+	}
+	for name, lines := range tests {
+		profile := findTelnetProfile(profiles, name)
+		for line, want := range lines {
+			if got := profile.ShellPrompt("banner\r\n" + line); got != want {
+				t.Errorf("%s: ShellPrompt(%q) = %v, want %v", name, line, got, want)
+			}
+		}
+	}
+}
+
+func TestTelnetNoPassword(t *testing.T) {
+	// 提交用户名后直接进入命令行：不发送密码，以单独的认证方式返回
+	profiles := loadDefaultTelnetProfiles(t)
+	for _, device := range telnetDevices {
+		mode, writes, err := runTelnetScript(profiles, device.banner, device.success)
+		if err != nil || mode != telnetAuthNoPassword {
+			t.Errorf("%s: mode %q, err %v", device.profile, mode, err)
+		}
+		if writes != 1 {
+			t.Errorf("%s: client wrote %d times, want only the username", device.profile, writes)
+		}
+	}
+
+	// 已上报的无密码账户不再对其余密码重复尝试，其他账户不受影响
+	target := &telnetTarget{tried: make(map[string]bool), noPassword: make(map[string]bool)}
+	target.record(telnetAuthNoPassword, "guest", "123456")
+	if skip, err := target.skip("guest", "admin"); !skip || err == nil {
+		t.Errorf("guest: skip = %v, err = %v, want skipped with an error", skip, err)
+	}
+	if skip, _ := target.skip("admin", "admin"); skip {
+		t.Error("admin skipped after guest was found without a password")
+	}
+}
+
+func TestTelnetNoFalseShell(t *testing.T) {
+	// 登录前的进度或菜单输出不能判定为无需认证
+	profiles := loadDefaultTelnetProfiles(t)
+	for _, banner := range []string{
+		"\r\nChecking file system...\r\nUpgrading firmware... 100%",
+		"\r\n1. Status\r\n2. Reboot\r\nPress # to continue#",
+	} {
+		if mode, err := runTelnetLogin(profiles, banner); err == nil || mode == telnetAuthNone {
+			t.Errorf("%q: mode %q, err %v, want no prompt", banner, mode, err)
+		}
+	}
+
+	// 提交密码后的进度输出不能判定为登录成功
+	for _, output := range []string{
+		"\r\nLoading profile... 100%",
+		"\r\nSession limit reached. Press # to continue#",
+	} {
+		if _, err := runTelnetLogin(profiles, "host login: ", "Password: ", output); err == nil {
+			t.Errorf("%q: reported as a successful login", output)
+		}
+	}
+}
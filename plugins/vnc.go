@@ -2,16 +2,81 @@ package plugins
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
-	"strings"
+	"regexp"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/mitchellh/go-vnc"
 	"github.com/zan8in/leo/internal/core"
 )
 
+// VNC 认证方式
+const (
+	vncAuthNone  = "none"
+	vncAuthVNC   = "vnc"   // VNC Authentication，仅密码
+	vncAuthPlain = "plain" // VeNCrypt Plain，用户名和密码
+	vncAuthARD   = "ard"   // Apple Remote Desktop，用户名和密码
+)
+
+var (
+	// errVncCredentialsRequired 服务端未提供无需认证的安全类型
+	errVncCredentialsRequired = errors.New("VNC requires credentials")
+	// errVncNoAuth 服务端无需认证，凭据无从验证
+	errVncNoAuth = errors.New("VNC requires no authentication, credentials not verified")
+	// errVncPasswordTried VNC Authentication 与用户名无关，该密码已尝试过
+	errVncPasswordTried = errors.New("VNC password already tried")
+)
+
+// vncDefaultCooldown 服务端将扫描源列入黑名单后的默认冷却时间，连续触发时逐次翻倍
+const vncDefaultCooldown = 30 * time.Second
+
+// vncBlacklistPattern RealVNC、TightVNC、libvncserver 等按来源 IP 封禁时返回的原因
+var vncBlacklistPattern = regexp.MustCompile(`(?i)too many|blacklist|try again later`)
+
+// vncMethod 选定的安全类型及认证方式
+type vncMethod struct {
+	SecType uint32
+	Sub     uint32 // Tight 认证能力（0 表示服务端未提供能力列表）或 VeNCrypt 子类型
+	Auth    string
+}
+
+// vncMethods 按优先级排列的认证方式：无需认证优先，其次使用用户名的认证，最后为仅密码的 VNC Authentication；
+// 使用用户名的认证失败时另行以同一密码尝试 VNC Authentication（见 VncScan）
+var vncMethods = []vncMethod{
+	{SecType: rfbSecNone, Auth: vncAuthNone},
+	{SecType: rfbSecTight, Auth: vncAuthNone},
+	{SecType: rfbSecTight, Sub: rfbTightNoAuth, Auth: vncAuthNone},
+	{SecType: rfbSecVeNCrypt, Sub: vencryptNone, Auth: vncAuthNone},
+	{SecType: rfbSecVeNCrypt, Sub: vencryptX509None, Auth: vncAuthNone},
+	{SecType: rfbSecARD, Auth: vncAuthARD},
+	{SecType: rfbSecVeNCrypt, Sub: vencryptX509Plain, Auth: vncAuthPlain},
+	{SecType: rfbSecVeNCrypt, Sub: vencryptPlain, Auth: vncAuthPlain},
+	{SecType: rfbSecVNCAuth, Auth: vncAuthVNC},
+	{SecType: rfbSecTight, Sub: rfbTightVNCAuth, Auth: vncAuthVNC},
+	{SecType: rfbSecVeNCrypt, Sub: vencryptVNCAuth, Auth: vncAuthVNC},
+	{SecType: rfbSecVeNCrypt, Sub: vencryptX509Vnc, Auth: vncAuthVNC},
+}
+
+// vncTarget 单个目标的 RFB 版本和安全类型，在一次扫描内按 host:port 缓存
+type vncTarget struct {
+	probeMu      sync.Mutex
+	probed       bool
+	Version      string
+	Types        []uint32
+	TightAuth    []uint32 // Tight 安全类型中的认证能力
+	TightUnknown bool     // Tight 能力列表读取失败（不同于表示无需认证的空列表），不使用 Tight 安全类型
+	VeNCrypt     []uint32 // VeNCrypt 子类型
+
+	mu       sync.Mutex
+	tried    map[string]bool // 仅密码认证已尝试过的密码
+	backoffs int             // 连续被列入黑名单的次数
+}
+
 // VncScan VNC弱口令扫描函数
+// 未提供凭据时上报 RFB 版本和安全类型，服务端提供 None 时作为未授权访问上报
+// 选项：cooldown=被列入黑名单后的冷却时间（默认 30s）
 func VncScan(info *core.HostInfo) error {
 	if info.Port == 0 {
 		info.Port = 5900 // VNC默认端口
@@ -25,6 +90,11 @@ func VncScan(info *core.HostInfo) error {
 		defer cancel()
 	}
 
+	timeout := info.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
 	// 检查context是否已取消
 	select {
 	case <-ctx.Done():
@@ -32,147 +102,344 @@ func VncScan(info *core.HostInfo) error {
 	default:
 	}
 
-	// 如果有指定密码，直接尝试该密码
-	if info.Password != "" {
-		success, sessionInfo, err := VncConn(ctx, info, info.Password)
-		if success {
-			fmt.Printf("[+] %s:%d vnc %s %s\n", info.Host, info.Port, info.Password, sessionInfo)
+	target, err := probeVncTarget(ctx, info, timeout)
+	if err != nil {
+		if reason, blacklisted := vncBlacklisted(err); blacklisted {
+			return target.backoff(info, reason)
 		}
-		return err
+		return fmt.Errorf("VNC handshake failed: %v", err)
 	}
+	method, supported := target.Method()
+
+	if info.Username == "" && info.Password == "" {
+		metadata := target.Metadata()
+		if supported && method.Auth == vncAuthNone {
+			serverInit, err := vncLogin(ctx, info, timeout, method, "", "")
+			if err != nil {
+				if reason, blacklisted := vncBlacklisted(err); blacklisted {
+					return target.backoff(info, reason)
+				}
+				return fmt.Errorf("VNC no-auth session failed: %v", err)
+			}
+			vncSessionMetadata(metadata, serverInit)
+			info.Report(&core.ScanResult{
+				Service:  "vnc",
+				Success:  true,
+				VulnType: "unauth",
+				Metadata: metadata,
+			})
+			return nil
+		}
 
-	// 尝试空密码认证
-	success, sessionInfo, err := VncConn(ctx, info, "")
-	if success {
-		fmt.Printf("[+] %s:%d vnc empty password %s\n", info.Host, info.Port, sessionInfo)
-		return nil
+		// 上报握手信息，返回错误使引擎继续弱口令检测
+		info.Report(&core.ScanResult{
+			Service:  "vnc",
+			Success:  true,
+			VulnType: "info",
+			Metadata: metadata,
+		})
+		return errVncCredentialsRequired
 	}
 
-	return err
-}
+	if !supported {
+		if unsupported := target.UnsupportedVeNCrypt(); len(unsupported) > 0 {
+			return fmt.Errorf("VNC security types not supported: %s (VeNCrypt %s requires anonymous TLS)",
+				rfbSecurityList(target.Types), rfbNameList(unsupported, vencryptNames))
+		}
+		return fmt.Errorf("VNC security types not supported: %s", rfbSecurityList(target.Types))
+	}
+	// 无需认证的目标已在空凭据阶段上报，凭据检测不产生新的结果
+	if method.Auth == vncAuthNone {
+		return errVncNoAuth
+	}
 
-// VncConn 尝试建立VNC连接并验证会话
-func VncConn(ctx context.Context, info *core.HostInfo, pass string) (bool, string, error) {
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return false, "", ctx.Err()
-	default:
+	username, serverInit, err := target.attempt(ctx, info, timeout, method)
+	// ARD、Plain 认证失败而服务端同时提供 VNC Authentication 时（如 macOS 同时提供类型 30 和 2），
+	// 以同一密码再尝试 VNC Authentication，每个密码只尝试一次
+	if isRfbAuthFailure(err) && method.Auth != vncAuthVNC {
+		if fallback, ok := target.passwordMethod(); ok {
+			if fallbackUser, fallbackInit, fallbackErr := target.attempt(ctx, info, timeout, fallback); !errors.Is(fallbackErr, errVncPasswordTried) {
+				method, username, serverInit, err = fallback, fallbackUser, fallbackInit, fallbackErr
+			}
+		}
 	}
+	if err != nil {
+		var backoff *core.BackoffError
+		if errors.As(err, &backoff) || errors.Is(err, errVncPasswordTried) {
+			return err
+		}
+		return fmt.Errorf("VNC authentication failed for %s:%s - %v", username, info.Password, err)
+	}
+
+	metadata := target.Metadata()
+	metadata["auth"] = method.Auth
+	vncSessionMetadata(metadata, serverInit)
+	info.Report(&core.ScanResult{
+		Service:  "vnc",
+		Username: username,
+		Password: info.Password,
+		Success:  true,
+		VulnType: "weak_password",
+		Metadata: metadata,
+	})
+	return nil
+}
 
-	addr := fmt.Sprintf("%s:%d", info.Host, info.Port)
+// probeVncTarget 读取服务端版本和安全类型，结果按目标缓存；
+// 只缓存成功的握手，超时、连接重置或黑名单等失败在下次检测时重新探测
+func probeVncTarget(ctx context.Context, info *core.HostInfo, timeout time.Duration) (*vncTarget, error) {
+	key := fmt.Sprintf("%s:%d", info.Host, info.Port)
+	value, _ := info.Cache("vnc").LoadOrStore(key, &vncTarget{tried: make(map[string]bool)})
+	target := value.(*vncTarget)
 
-	// 创建带超时的TCP连接
-	var dialer net.Dialer
-	conn, err := dialer.DialContext(ctx, "tcp", addr)
-	if err != nil {
-		return false, "", err
+	target.probeMu.Lock()
+	defer target.probeMu.Unlock()
+	if !target.probed {
+		if err := target.discover(ctx, info, timeout); err != nil {
+			return target, err
+		}
+		target.probed = true
 	}
-	defer conn.Close()
+	return target, nil
+}
 
-	// 检查context是否已取消
-	select {
-	case <-ctx.Done():
-		return false, "", ctx.Err()
-	default:
+// discover 完成一次握手，提供 Tight 或 VeNCrypt 时另行读取其认证能力或子类型（每个连接只能选择一种安全类型）
+func (t *vncTarget) discover(ctx context.Context, info *core.HostInfo, timeout time.Duration) error {
+	c, err := dialRfb(ctx, info.Host, info.Port, timeout)
+	if err != nil {
+		return err
 	}
+	t.Version = c.Version
+	t.Types = c.Types
 
-	// 如果密码为空，先尝试无认证连接
-	if pass == "" {
-		// 尝试无认证连接
-		vncConn, err := vnc.Client(conn, &vnc.ClientConfig{})
-		if err == nil {
-			defer vncConn.Close()
-			// 验证会话信息
-			sessionInfo := validateVncSession(vncConn)
-			if sessionInfo != "" {
-				return true, fmt.Sprintf("(unauthorized access) %s", sessionInfo), nil
+	// 能力列表读取失败不影响其他安全类型
+	if c.Offers(rfbSecTight) {
+		t.TightAuth, t.TightUnknown = nil, true
+		if err := c.Select(rfbSecTight); err == nil {
+			if auth, err := c.TightAuthTypes(); err == nil {
+				t.TightAuth, t.TightUnknown = auth, false
 			}
 		}
+		c.Close()
+		if c, err = dialRfb(ctx, info.Host, info.Port, timeout); err != nil {
+			return nil
+		}
+	}
+	defer c.Close()
+	if c.Offers(rfbSecVeNCrypt) {
+		if err := c.Select(rfbSecVeNCrypt); err == nil {
+			t.VeNCrypt, _ = c.VeNCryptSubtypes()
+		}
+	}
+	return nil
+}
 
-		// 无认证失败，检查错误类型
-		if strings.Contains(err.Error(), "authentication") || strings.Contains(err.Error(), "security") {
-			// 这是正常的认证要求，重新建立连接尝试空密码
-			conn.Close()
-			conn, err = dialer.DialContext(ctx, "tcp", addr)
-			if err != nil {
-				return false, "", err
-			}
-			defer conn.Close()
+// Method 按 vncMethods 的优先级选择服务端提供的认证方式
+func (t *vncTarget) Method() (vncMethod, bool) {
+	for _, method := range vncMethods {
+		if t.offers(method) {
+			return method, true
+		}
+	}
+	return vncMethod{}, false
+}
 
-			// 尝试空密码认证
-			config := &vnc.ClientConfig{
-				Auth: []vnc.ClientAuth{
-					&vnc.PasswordAuth{Password: ""},
-				},
-			}
+// passwordMethod 服务端提供的 VNC Authentication 方式
+func (t *vncTarget) passwordMethod() (vncMethod, bool) {
+	for _, method := range vncMethods {
+		if method.Auth == vncAuthVNC && t.offers(method) {
+			return method, true
+		}
+	}
+	return vncMethod{}, false
+}
 
-			vncConn, err := vnc.Client(conn, config)
-			if err != nil {
-				return false, "", err
-			}
-			defer vncConn.Close()
+// UnsupportedVeNCrypt 服务端提供但无法使用的 VeNCrypt 子类型（匿名 TLS、SASL 等）
+func (t *vncTarget) UnsupportedVeNCrypt() []uint32 {
+	var unsupported []uint32
+	for _, subtype := range t.VeNCrypt {
+		if !slices.ContainsFunc(vncMethods, func(method vncMethod) bool {
+			return method.SecType == rfbSecVeNCrypt && method.Sub == subtype
+		}) {
+			unsupported = append(unsupported, subtype)
+		}
+	}
+	return unsupported
+}
 
-			// 验证会话信息
-			sessionInfo := validateVncSession(vncConn)
-			if sessionInfo != "" {
-				return true, sessionInfo, nil
-			}
-			return false, "", fmt.Errorf("VNC session validation failed")
+// offers 服务端是否提供该认证方式
+func (t *vncTarget) offers(method vncMethod) bool {
+	if !slices.Contains(t.Types, method.SecType) {
+		return false
+	}
+	switch method.SecType {
+	case rfbSecTight:
+		if t.TightUnknown {
+			return false
 		}
+		if method.Sub == 0 {
+			return len(t.TightAuth) == 0
+		}
+		return slices.Contains(t.TightAuth, method.Sub)
+	case rfbSecVeNCrypt:
+		return slices.Contains(t.VeNCrypt, method.Sub)
+	}
+	return true
+}
 
-		// 其他类型的错误（连接问题等）
-		return false, "", err
+// Metadata 握手信息转换为结果元数据
+func (t *vncTarget) Metadata() map[string]string {
+	metadata := map[string]string{
+		"version":  t.Version,
+		"security": rfbSecurityList(t.Types),
 	}
+	if t.TightUnknown {
+		metadata["tight_auth"] = "unknown"
+	} else if len(t.TightAuth) > 0 {
+		metadata["tight_auth"] = rfbTightAuthList(t.TightAuth)
+	}
+	if len(t.VeNCrypt) > 0 {
+		metadata["vencrypt"] = rfbNameList(t.VeNCrypt, vencryptNames)
+	}
+	// 匿名 TLS 等无法协商的子类型单独列出，只提供这些子类型的目标无法检测弱口令
+	if unsupported := t.UnsupportedVeNCrypt(); len(unsupported) > 0 {
+		metadata["vencrypt_unsupported"] = rfbNameList(unsupported, vencryptNames)
+	}
+	return metadata
+}
 
-	// 使用指定密码进行认证
-	config := &vnc.ClientConfig{
-		Auth: []vnc.ClientAuth{
-			&vnc.PasswordAuth{Password: pass},
-		},
+// try 记录即将尝试的密码，已尝试过时返回 false
+func (t *vncTarget) try(password string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tried[password] {
+		return false
 	}
+	t.tried[password] = true
+	return true
+}
 
-	vncConn, err := vnc.Client(conn, config)
-	if err != nil {
-		return false, "", err
+// forget 撤销未得到明确结果的尝试记录
+func (t *vncTarget) forget(password string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tried, password)
+}
+
+// attempt 以选定方式检测一次凭据，返回结果中记录的用户名；
+// VNC Authentication 与用户名无关，同一密码只尝试一次
+func (t *vncTarget) attempt(ctx context.Context, info *core.HostInfo, timeout time.Duration, method vncMethod) (string, *rfbServerInit, error) {
+	username := info.Username
+	passwordOnly := method.Auth == vncAuthVNC
+	if passwordOnly {
+		username = ""
+		if !t.try(info.Password) {
+			return username, nil, errVncPasswordTried
+		}
 	}
-	defer vncConn.Close()
 
-	// 验证会话信息
-	sessionInfo := validateVncSession(vncConn)
-	if sessionInfo != "" {
-		return true, sessionInfo, nil
+	serverInit, err := vncLogin(ctx, info, timeout, method, username, info.Password)
+	if reason, blacklisted := vncBlacklisted(err); blacklisted {
+		// 黑名单期间服务端不校验密码，冷却后由引擎重试该密码
+		if passwordOnly {
+			t.forget(info.Password)
+		}
+		return username, nil, t.backoff(info, reason)
 	}
+	if err == nil || isRfbAuthFailure(err) {
+		t.resetBackoff()
+	} else if passwordOnly {
+		// 网络错误等不确定的结果允许重试
+		t.forget(info.Password)
+	}
+	return username, serverInit, err
+}
+
+// backoff 记录一次黑名单，返回供引擎调度的冷却时间
+func (t *vncTarget) backoff(info *core.HostInfo, reason string) error {
+	t.mu.Lock()
+	t.backoffs++
+	count := t.backoffs
+	t.mu.Unlock()
 
-	return false, "", fmt.Errorf("VNC session validation failed")
+	delay := info.OptionDuration("cooldown", vncDefaultCooldown) << min(count-1, 3)
+	return &core.BackoffError{Delay: delay, Reason: "vnc: " + reason}
 }
 
-// validateVncSession 验证VNC会话并获取会话信息
-func validateVncSession(conn *vnc.ClientConn) string {
-	if conn == nil {
-		return ""
-	}
+// resetBackoff 服务端恢复校验密码后清除黑名单计数
+func (t *vncTarget) resetBackoff() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backoffs = 0
+}
 
-	// 获取桌面信息
-	desktopName := conn.DesktopName
-	width := conn.FrameBufferWidth
-	height := conn.FrameBufferHeight
+// vncBlacklisted 判断服务端是否因认证失败过多暂时拒绝扫描源，返回失败原因
+func vncBlacklisted(err error) (string, bool) {
+	var failure *rfbFailure
+	if errors.As(err, &failure) && vncBlacklistPattern.MatchString(failure.Reason) {
+		return failure.Reason, true
+	}
+	var authFailure *rfbAuthFailure
+	if errors.As(err, &authFailure) && (authFailure.Status == rfbResultTooMany || vncBlacklistPattern.MatchString(authFailure.Reason)) {
+		return authFailure.Reason, true
+	}
+	return "", false
+}
 
-	// 尝试请求帧缓冲区更新来验证连接有效性
-	err := conn.FramebufferUpdateRequest(false, 0, 0, 1, 1)
+// vncLogin 按选定方式完成认证并交换初始化消息
+func vncLogin(ctx context.Context, info *core.HostInfo, timeout time.Duration, method vncMethod, username, password string) (*rfbServerInit, error) {
+	c, err := dialRfb(ctx, info.Host, info.Port, timeout)
 	if err != nil {
-		return ""
+		return nil, err
 	}
+	defer c.Close()
 
-	// 构建会话信息字符串
-	sessionInfo := fmt.Sprintf("desktop:'%s' resolution:%dx%d", desktopName, width, height)
-	
-	// 如果桌面名称为空，使用默认描述
-	if desktopName == "" {
-		sessionInfo = fmt.Sprintf("resolution:%dx%d", width, height)
+	if err := c.Select(method.SecType); err != nil {
+		return nil, err
 	}
+	switch method.SecType {
+	case rfbSecTight:
+		if _, err := c.TightAuthTypes(); err != nil {
+			return nil, err
+		}
+		if method.Sub != 0 {
+			if err := c.SelectTight(method.Sub); err != nil {
+				return nil, err
+			}
+		}
+	case rfbSecVeNCrypt:
+		if _, err := c.VeNCryptSubtypes(); err != nil {
+			return nil, err
+		}
+		if err := c.SelectVeNCrypt(method.Sub); err != nil {
+			return nil, err
+		}
+	}
+
+	switch method.Auth {
+	case vncAuthNone:
+		err = c.AuthNone()
+	case vncAuthVNC:
+		err = c.AuthVNC(password)
+	case vncAuthPlain:
+		err = c.AuthPlain(username, password)
+	case vncAuthARD:
+		err = c.AuthARD(username, password)
+	default:
+		err = fmt.Errorf("unsupported VNC auth: %s", method.Auth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.Init()
+}
 
-	return sessionInfo
+// vncSessionMetadata 记录 ServerInit 中的桌面名称和分辨率
+func vncSessionMetadata(metadata map[string]string, serverInit *rfbServerInit) {
+	if serverInit.Name != "" {
+		metadata["desktop"] = serverInit.Name
+	}
+	metadata["resolution"] = fmt.Sprintf("%dx%d", serverInit.Width, serverInit.Height)
 }
 
 // 注册插件
@@ -0,0 +1,190 @@
+package plugins
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// VeNCrypt 子类型
+const (
+	vencryptNone      = 1
+	vencryptVNCAuth   = 2
+	vencryptPlain     = 256
+	vencryptTLSNone   = 257 // TLS* 子类型使用匿名 Diffie-Hellman TLS
+	vencryptTLSVnc    = 258
+	vencryptTLSPlain  = 259
+	vencryptX509None  = 260 // X509* 子类型使用证书 TLS
+	vencryptX509Vnc   = 261
+	vencryptX509Plain = 262
+	vencryptTLSSASL   = 263
+	vencryptX509SASL  = 264
+)
+
+// vencryptNames VeNCrypt 子类型名称
+var vencryptNames = map[uint32]string{
+	vencryptNone:      "none",
+	vencryptVNCAuth:   "vnc",
+	vencryptPlain:     "plain",
+	vencryptTLSNone:   "tls-none",
+	vencryptTLSVnc:    "tls-vnc",
+	vencryptTLSPlain:  "tls-plain",
+	vencryptX509None:  "x509-none",
+	vencryptX509Vnc:   "x509-vnc",
+	vencryptX509Plain: "x509-plain",
+	vencryptTLSSASL:   "tls-sasl",
+	vencryptX509SASL:  "x509-sasl",
+}
+
+// ARD 认证中用户名和密码各占 64 字节（含结尾 NUL）
+const ardFieldSize = 64
+
+// VeNCryptSubtypes 选择 VeNCrypt 安全类型后协商 0.2 版本并读取子类型列表
+func (c *rfbConn) VeNCryptSubtypes() ([]uint32, error) {
+	c.deadline()
+
+	var version [2]byte
+	if _, err := io.ReadFull(c.conn, version[:]); err != nil {
+		return nil, err
+	}
+	if version[0] != 0 || version[1] < 2 {
+		return nil, fmt.Errorf("unsupported VeNCrypt version: %d.%d", version[0], version[1])
+	}
+	if _, err := c.conn.Write([]byte{0, 2}); err != nil {
+		return nil, err
+	}
+
+	var status [2]byte // 版本确认和子类型数量
+	if _, err := io.ReadFull(c.conn, status[:]); err != nil {
+		return nil, err
+	}
+	if status[0] != 0 {
+		return nil, fmt.Errorf("VeNCrypt version 0.2 rejected")
+	}
+	subtypes := make([]uint32, status[1])
+	if err := binary.Read(c.conn, binary.BigEndian, subtypes); err != nil {
+		return nil, err
+	}
+	return subtypes, nil
+}
+
+// SelectVeNCrypt 选择 VeNCrypt 子类型，X509 子类型在服务端确认后升级为 TLS
+// 匿名 TLS（TLS* 子类型）依赖 crypto/tls 不支持的 ADH 套件，不可选择
+func (c *rfbConn) SelectVeNCrypt(subtype uint32) error {
+	c.deadline()
+	if err := binary.Write(c.conn, binary.BigEndian, subtype); err != nil {
+		return err
+	}
+
+	switch subtype {
+	case vencryptNone, vencryptVNCAuth, vencryptPlain:
+		return nil
+	case vencryptX509None, vencryptX509Vnc, vencryptX509Plain:
+	default:
+		return fmt.Errorf("unsupported VeNCrypt subtype: %s", rfbNameList([]uint32{subtype}, vencryptNames))
+	}
+
+	var ack [1]byte
+	if _, err := io.ReadFull(c.conn, ack[:]); err != nil {
+		return err
+	}
+	if ack[0] != 1 {
+		return fmt.Errorf("VeNCrypt subtype %s rejected", vencryptNames[subtype])
+	}
+
+	tlsConn := tls.Client(c.conn, &tls.Config{
+		InsecureSkipVerify: true, // 跳过证书验证
+		ServerName:         c.host,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	return nil
+}
+
+// AuthPlain VeNCrypt Plain 认证：明文发送用户名和密码（X509Plain 时位于 TLS 内）
+func (c *rfbConn) AuthPlain(username, password string) error {
+	c.deadline()
+
+	message := binary.BigEndian.AppendUint32(nil, uint32(len(username)))
+	message = binary.BigEndian.AppendUint32(message, uint32(len(password)))
+	message = append(message, username...)
+	message = append(message, password...)
+	if _, err := c.conn.Write(message); err != nil {
+		return err
+	}
+	return c.securityResult()
+}
+
+// AuthARD Apple Remote Desktop 认证：Diffie-Hellman 协商密钥，
+// 以共享密钥的 MD5 作为 AES-128-ECB 密钥加密 128 字节的用户名和密码
+func (c *rfbConn) AuthARD(username, password string) error {
+	c.deadline()
+
+	var header [4]byte // 生成元和密钥长度
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return err
+	}
+	generator := new(big.Int).SetUint64(uint64(binary.BigEndian.Uint16(header[0:2])))
+	keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+	if keyLength == 0 || keyLength > 1024 {
+		return fmt.Errorf("invalid ARD key length: %d", keyLength)
+	}
+	params := make([]byte, keyLength*2)
+	if _, err := io.ReadFull(c.conn, params); err != nil {
+		return err
+	}
+	prime := new(big.Int).SetBytes(params[:keyLength])
+	serverPublic := new(big.Int).SetBytes(params[keyLength:])
+	if prime.Sign() == 0 {
+		return fmt.Errorf("invalid ARD prime")
+	}
+
+	private, err := rand.Int(rand.Reader, prime)
+	if err != nil {
+		return err
+	}
+	public := new(big.Int).Exp(generator, private, prime)
+	shared := new(big.Int).Exp(serverPublic, private, prime)
+	key := md5.Sum(shared.FillBytes(make([]byte, keyLength)))
+
+	credentials, err := ardCredentials(username, password)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(credentials); i += aes.BlockSize {
+		block.Encrypt(credentials[i:i+aes.BlockSize], credentials[i:i+aes.BlockSize])
+	}
+
+	response := append(credentials, public.FillBytes(make([]byte, keyLength))...)
+	if _, err := c.conn.Write(response); err != nil {
+		return err
+	}
+	return c.securityResult()
+}
+
+// ardCredentials 构造 ARD 凭据块：用户名和密码各占 64 字节，以 NUL 结尾，其余填充随机数据
+func ardCredentials(username, password string) ([]byte, error) {
+	if len(username) >= ardFieldSize || len(password) >= ardFieldSize {
+		return nil, fmt.Errorf("ARD username and password must be shorter than %d bytes", ardFieldSize)
+	}
+	credentials := make([]byte, ardFieldSize*2)
+	if _, err := rand.Read(credentials); err != nil {
+		return nil, err
+	}
+	copy(credentials, username)
+	credentials[len(username)] = 0
+	copy(credentials[ardFieldSize:], password)
+	credentials[ardFieldSize+len(password)] = 0
+	return credentials, nil
+}
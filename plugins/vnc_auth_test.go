@@ -0,0 +1,144 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAuthARD(t *testing.T) {
+	var username, password string
+	c := pipeRfb(t, 8, func(server net.Conn) error {
+		var err error
+		if username, password, err = serveARD(server, ardTestPrime); err != nil {
+			return err
+		}
+		return binary.Write(server, binary.BigEndian, uint32(rfbResultOK))
+	})
+	if err := c.AuthARD("admin", "P@ssw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	// 服务端用自己的 DH 私钥解密出的凭据
+	if username != "admin" || password != "P@ssw0rd" {
+		t.Errorf("server decrypted %q/%q", username, password)
+	}
+
+	// 认证失败时返回服务端的原因
+	c = pipeRfb(t, 8, func(server net.Conn) error {
+		if _, _, err := serveARD(server, ardTestPrime); err != nil {
+			return err
+		}
+		reason := "Authentication failed"
+		message := binary.BigEndian.AppendUint32(nil, rfbResultFailed)
+		message = binary.BigEndian.AppendUint32(message, uint32(len(reason)))
+		_, err := server.Write(append(message, reason...))
+		return err
+	})
+	err := c.AuthARD("admin", "wrong")
+	var failure *rfbAuthFailure
+	if !errors.As(err, &failure) || failure.Reason != "Authentication failed" {
+		t.Errorf("err = %v, want rfbAuthFailure", err)
+	}
+}
+
+func TestArdCredentials(t *testing.T) {
+	credentials, err := ardCredentials("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != ardFieldSize*2 {
+		t.Fatalf("len = %d, want %d", len(credentials), ardFieldSize*2)
+	}
+	if !bytes.HasPrefix(credentials, []byte("admin\x00")) || !bytes.HasPrefix(credentials[ardFieldSize:], []byte("secret\x00")) {
+		t.Errorf("credentials = %x", credentials)
+	}
+
+	// 63 字节加结尾 NUL 恰好填满字段
+	long := strings.Repeat("a", ardFieldSize-1)
+	if credentials, err = ardCredentials(long, long); err != nil || credentials[ardFieldSize-1] != 0 || credentials[len(credentials)-1] != 0 {
+		t.Errorf("63-byte fields: err %v", err)
+	}
+	if _, err := ardCredentials(long+"a", "secret"); err == nil {
+		t.Error("64-byte username accepted")
+	}
+	if _, err := ardCredentials("admin", long+"a"); err == nil {
+		t.Error("64-byte password accepted")
+	}
+}
+
+// serveVeNCrypt 服务端一侧的 VeNCrypt 0.2 版本协商，发送子类型列表
+func serveVeNCrypt(server net.Conn, version byte, subtypes ...uint32) error {
+	if _, err := server.Write([]byte{0, version}); err != nil {
+		return err
+	}
+	if version < 2 {
+		return nil
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(server, reply[:]); err != nil {
+		return err
+	}
+	if reply != [2]byte{0, 2} {
+		return fmt.Errorf("client replied VeNCrypt version %d.%d", reply[0], reply[1])
+	}
+	message := []byte{0, byte(len(subtypes))}
+	for _, subtype := range subtypes {
+		message = binary.BigEndian.AppendUint32(message, subtype)
+	}
+	_, err := server.Write(message)
+	return err
+}
+
+func TestVeNCryptSubtypes(t *testing.T) {
+	c := pipeRfb(t, 8, func(server net.Conn) error {
+		return serveVeNCrypt(server, 2, vencryptPlain, vencryptTLSNone, vencryptTLSVnc, vencryptTLSPlain, vencryptX509Plain)
+	})
+	subtypes, err := c.VeNCryptSubtypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rfbNameList(subtypes, vencryptNames); got != "plain,tls-none,tls-vnc,tls-plain,x509-plain" {
+		t.Errorf("subtypes = %s", got)
+	}
+
+	// 0.1 版本不支持
+	c = pipeRfb(t, 8, func(server net.Conn) error {
+		return serveVeNCrypt(server, 1)
+	})
+	if _, err := c.VeNCryptSubtypes(); err == nil {
+		t.Error("VeNCrypt 0.1 accepted")
+	}
+
+	// 选择 Plain 子类型后直接进行 Plain 认证
+	var selected uint32
+	var username, password string
+	c = pipeRfb(t, 8, func(server net.Conn) error {
+		if err := binary.Read(server, binary.BigEndian, &selected); err != nil {
+			return err
+		}
+		var lengths [2]uint32
+		if err := binary.Read(server, binary.BigEndian, &lengths); err != nil {
+			return err
+		}
+		data := make([]byte, lengths[0]+lengths[1])
+		if _, err := io.ReadFull(server, data); err != nil {
+			return err
+		}
+		username, password = string(data[:lengths[0]]), string(data[lengths[0]:])
+		return binary.Write(server, binary.BigEndian, uint32(rfbResultOK))
+	})
+	if err := c.SelectVeNCrypt(vencryptPlain); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AuthPlain("root", "toor"); err != nil {
+		t.Fatal(err)
+	}
+	if selected != vencryptPlain || username != "root" || password != "toor" {
+		t.Errorf("server saw subtype %d, %q/%q", selected, username, password)
+	}
+}
@@ -0,0 +1,435 @@
+package plugins
+
+import (
+	"context"
+	"crypto/des"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFB 安全类型（RFC 6143 7.1.2 及 IANA 注册表）
+const (
+	rfbSecInvalid   = 0
+	rfbSecNone      = 1
+	rfbSecVNCAuth   = 2
+	rfbSecRA2       = 5
+	rfbSecRA2ne     = 6
+	rfbSecTight     = 16
+	rfbSecUltra     = 17
+	rfbSecTLS       = 18
+	rfbSecVeNCrypt  = 19
+	rfbSecSASL      = 20
+	rfbSecMD5       = 21
+	rfbSecXVP       = 22
+	rfbSecARD       = 30 // Apple Remote Desktop（Diffie-Hellman）
+	rfbSecMSLogonII = 113
+)
+
+// rfbSecurityNames 安全类型名称，用于结果上报
+var rfbSecurityNames = map[uint32]string{
+	rfbSecNone:      "none",
+	rfbSecVNCAuth:   "vnc",
+	rfbSecRA2:       "ra2",
+	rfbSecRA2ne:     "ra2ne",
+	rfbSecTight:     "tight",
+	rfbSecUltra:     "ultra",
+	rfbSecTLS:       "tls",
+	rfbSecVeNCrypt:  "vencrypt",
+	rfbSecSASL:      "sasl",
+	rfbSecMD5:       "md5",
+	rfbSecXVP:       "xvp",
+	rfbSecARD:       "ard",
+	rfbSecMSLogonII: "mslogon2",
+}
+
+// Tight 安全类型中的认证能力代码
+const (
+	rfbTightNoAuth   = 1
+	rfbTightVNCAuth  = 2
+	rfbTightUnix     = 129
+	rfbTightExternal = 130
+)
+
+// rfbTightAuthNames Tight 认证能力名称
+var rfbTightAuthNames = map[uint32]string{
+	rfbTightNoAuth:   "none",
+	rfbTightVNCAuth:  "vnc",
+	rfbTightUnix:     "unix",
+	rfbTightExternal: "external",
+}
+
+// SecurityResult 状态
+const (
+	rfbResultOK      = 0
+	rfbResultFailed  = 1
+	rfbResultTooMany = 2 // 旧版服务端表示认证失败次数过多
+)
+
+const (
+	rfbMaxReason = 4096    // 失败原因字符串上限
+	rfbMaxName   = 1 << 16 // 桌面名称上限
+)
+
+// rfbFailure 服务端在握手阶段返回的失败原因（安全类型列表为空时）
+type rfbFailure struct {
+	Reason string
+}
+
+func (e *rfbFailure) Error() string {
+	return fmt.Sprintf("RFB connection failed: %s", e.Reason)
+}
+
+// rfbAuthFailure SecurityResult 表示认证失败，Reason 仅 3.8 协议提供
+type rfbAuthFailure struct {
+	Status uint32
+	Reason string
+}
+
+func (e *rfbAuthFailure) Error() string {
+	if e.Reason == "" {
+		return "RFB authentication failed"
+	}
+	return fmt.Sprintf("RFB authentication failed: %s", e.Reason)
+}
+
+// rfbServerInit ServerInit 消息中的会话信息
+type rfbServerInit struct {
+	Width  uint16
+	Height uint16
+	Name   string
+}
+
+// rfbConn RFB 握手阶段的连接
+type rfbConn struct {
+	conn    net.Conn
+	host    string
+	ctx     context.Context
+	timeout time.Duration
+
+	Version string   // 服务端声明的版本，如 3.8、3.889（Apple）
+	minor   int      // 协商使用的次版本：3、7 或 8
+	Types   []uint32 // 服务端提供的安全类型（3.3 协议下由服务端指定唯一类型）
+}
+
+// dialRfb 建立连接并完成版本协商，读取服务端提供的安全类型
+func dialRfb(ctx context.Context, host string, port int, timeout time.Duration) (*rfbConn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &rfbConn{conn: conn, host: host, ctx: ctx, timeout: timeout}
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close 关闭连接
+func (c *rfbConn) Close() error {
+	return c.conn.Close()
+}
+
+// deadline 每个阶段的读写截止时间，不超过 context 截止时间
+func (c *rfbConn) deadline() {
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := c.ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	c.conn.SetDeadline(deadline)
+}
+
+// handshake 交换 ProtocolVersion 并读取安全类型列表
+func (c *rfbConn) handshake() error {
+	c.deadline()
+
+	var banner [12]byte
+	if _, err := io.ReadFull(c.conn, banner[:]); err != nil {
+		return err
+	}
+	major, minor, err := parseRfbVersion(banner[:])
+	if err != nil {
+		return err
+	}
+	c.Version = fmt.Sprintf("%d.%d", major, minor)
+
+	// 客户端只支持 3.3、3.7、3.8，取不高于服务端的版本；3.4/3.6（UltraVNC）按 3.3 处理，3.889（Apple）按 3.8 处理
+	switch {
+	case minor >= 8:
+		c.minor = 8
+	case minor == 7:
+		c.minor = 7
+	default:
+		c.minor = 3
+	}
+	if _, err := fmt.Fprintf(c.conn, "RFB 003.%03d\n", c.minor); err != nil {
+		return err
+	}
+
+	if c.minor == 3 {
+		// 3.3 协议由服务端直接指定安全类型
+		var secType uint32
+		if err := binary.Read(c.conn, binary.BigEndian, &secType); err != nil {
+			return err
+		}
+		if secType == rfbSecInvalid {
+			return c.failure()
+		}
+		c.Types = []uint32{secType}
+		return nil
+	}
+
+	var count uint8
+	if err := binary.Read(c.conn, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return c.failure()
+	}
+	types := make([]byte, count)
+	if _, err := io.ReadFull(c.conn, types); err != nil {
+		return err
+	}
+	for _, t := range types {
+		c.Types = append(c.Types, uint32(t))
+	}
+	return nil
+}
+
+// parseRfbVersion 解析 "RFB xxx.yyy\n"
+func parseRfbVersion(banner []byte) (int, int, error) {
+	text := string(banner)
+	if !strings.HasPrefix(text, "RFB ") || text[7] != '.' || text[11] != '\n' {
+		return 0, 0, fmt.Errorf("invalid RFB version: %q", text)
+	}
+	major, err1 := strconv.Atoi(text[4:7])
+	minor, err2 := strconv.Atoi(text[8:11])
+	if err1 != nil || err2 != nil || major != 3 {
+		return 0, 0, fmt.Errorf("unsupported RFB version: %q", strings.TrimSpace(text))
+	}
+	return major, minor, nil
+}
+
+// failure 读取失败原因字符串
+func (c *rfbConn) failure() error {
+	reason, err := c.readString(rfbMaxReason)
+	if err != nil {
+		return err
+	}
+	return &rfbFailure{Reason: reason}
+}
+
+// readString 读取 U32 长度前缀的字符串
+func (c *rfbConn) readString(limit uint32) (string, error) {
+	var length uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if length > limit {
+		return "", fmt.Errorf("RFB string too long: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Offers 服务端是否提供指定安全类型
+func (c *rfbConn) Offers(secType uint32) bool {
+	return slices.Contains(c.Types, secType)
+}
+
+// Select 选择安全类型，3.3 协议中类型由服务端指定，无需发送
+func (c *rfbConn) Select(secType uint32) error {
+	if !c.Offers(secType) {
+		return fmt.Errorf("RFB security type %s not offered", rfbSecurityName(secType))
+	}
+	if c.minor == 3 {
+		return nil
+	}
+	c.deadline()
+	_, err := c.conn.Write([]byte{byte(secType)})
+	return err
+}
+
+// AuthNone 完成 None 认证，3.8 协议仍会返回 SecurityResult
+func (c *rfbConn) AuthNone() error {
+	if c.minor < 8 {
+		return nil
+	}
+	return c.securityResult()
+}
+
+// AuthVNC 完成 VNC Authentication：以密码为 DES 密钥加密 16 字节挑战
+func (c *rfbConn) AuthVNC(password string) error {
+	c.deadline()
+
+	challenge := make([]byte, 16)
+	if _, err := io.ReadFull(c.conn, challenge); err != nil {
+		return err
+	}
+	response, err := vncAuthResponse(password, challenge)
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(response); err != nil {
+		return err
+	}
+	return c.securityResult()
+}
+
+// vncAuthResponse 计算挑战应答，密码截断或补零到 8 字节，每字节按位反转作为 DES 密钥
+func vncAuthResponse(password string, challenge []byte) ([]byte, error) {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		b = (b&0xF0)>>4 | (b&0x0F)<<4
+		b = (b&0xCC)>>2 | (b&0x33)<<2
+		b = (b&0xAA)>>1 | (b&0x55)<<1
+		key[i] = b
+	}
+
+	block, err := des.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]byte, len(challenge))
+	for i := 0; i < len(challenge); i += des.BlockSize {
+		block.Encrypt(response[i:i+des.BlockSize], challenge[i:i+des.BlockSize])
+	}
+	return response, nil
+}
+
+// TightAuthTypes 选择 Tight 安全类型后读取隧道和认证能力列表，返回认证能力代码（为空表示无需认证）
+func (c *rfbConn) TightAuthTypes() ([]uint32, error) {
+	c.deadline()
+
+	tunnels, err := c.readTightCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	if len(tunnels) > 0 {
+		// NOTUNNEL
+		if err := binary.Write(c.conn, binary.BigEndian, uint32(0)); err != nil {
+			return nil, err
+		}
+	}
+	return c.readTightCapabilities()
+}
+
+// readTightCapabilities 读取 Tight 能力列表（每项为 4 字节代码、4 字节厂商和 8 字节名称）
+func (c *rfbConn) readTightCapabilities() ([]uint32, error) {
+	var count uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if count > 64 {
+		return nil, fmt.Errorf("too many Tight capabilities: %d", count)
+	}
+	codes := make([]uint32, count)
+	for i := range codes {
+		var capability [16]byte
+		if _, err := io.ReadFull(c.conn, capability[:]); err != nil {
+			return nil, err
+		}
+		codes[i] = binary.BigEndian.Uint32(capability[:4])
+	}
+	return codes, nil
+}
+
+// SelectTight 选择 Tight 认证能力
+func (c *rfbConn) SelectTight(code uint32) error {
+	c.deadline()
+	return binary.Write(c.conn, binary.BigEndian, code)
+}
+
+// securityResult 读取 SecurityResult，3.8 协议在失败时附带原因
+func (c *rfbConn) securityResult() error {
+	c.deadline()
+
+	var status uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &status); err != nil {
+		return err
+	}
+	if status == rfbResultOK {
+		return nil
+	}
+
+	failure := &rfbAuthFailure{Status: status}
+	if c.minor >= 8 {
+		if reason, err := c.readString(rfbMaxReason); err == nil {
+			failure.Reason = reason
+		}
+	}
+	if failure.Reason == "" && status == rfbResultTooMany {
+		failure.Reason = "too many authentication failures"
+	}
+	return failure
+}
+
+// Init 发送 ClientInit（共享会话）并读取 ServerInit，确认会话已建立
+func (c *rfbConn) Init() (*rfbServerInit, error) {
+	c.deadline()
+
+	if _, err := c.conn.Write([]byte{1}); err != nil {
+		return nil, err
+	}
+
+	// 宽、高各 2 字节，像素格式 16 字节
+	var header [20]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return nil, err
+	}
+	name, err := c.readString(rfbMaxName)
+	if err != nil {
+		return nil, err
+	}
+	return &rfbServerInit{
+		Width:  binary.BigEndian.Uint16(header[0:2]),
+		Height: binary.BigEndian.Uint16(header[2:4]),
+		Name:   name,
+	}, nil
+}
+
+// rfbSecurityName 安全类型名称，未知类型返回编号
+func rfbSecurityName(secType uint32) string {
+	return rfbNameList([]uint32{secType}, rfbSecurityNames)
+}
+
+// rfbSecurityList 格式化安全类型列表
+func rfbSecurityList(types []uint32) string {
+	return rfbNameList(types, rfbSecurityNames)
+}
+
+// rfbTightAuthList 格式化 Tight 认证能力列表
+func rfbTightAuthList(codes []uint32) string {
+	return rfbNameList(codes, rfbTightAuthNames)
+}
+
+func rfbNameList(codes []uint32, known map[uint32]string) string {
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		if name, ok := known[code]; ok {
+			names[i] = name
+		} else {
+			names[i] = strconv.FormatUint(uint64(code), 10)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// isRfbAuthFailure 判断是否为服务端明确拒绝认证
+func isRfbAuthFailure(err error) bool {
+	var failure *rfbAuthFailure
+	return errors.As(err, &failure)
+}
@@ -0,0 +1,218 @@
+package plugins
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// pipeRfb 通过 net.Pipe 连接客户端和测试服务端，serve 在协程中执行服务端一侧的交换
+func pipeRfb(t *testing.T, minor int, serve func(server net.Conn) error) *rfbConn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(server)
+	}()
+	t.Cleanup(func() {
+		if err := <-errc; err != nil {
+			t.Errorf("server: %v", err)
+		}
+	})
+	return &rfbConn{conn: client, host: "127.0.0.1", ctx: context.Background(), timeout: 2 * time.Second, minor: minor}
+}
+
+func TestVncAuthResponse(t *testing.T) {
+	// 应答由 openssl des-ecb 以按位反转后的密码为密钥独立计算
+	challenge, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	tests := []struct {
+		password string
+		response string
+	}{
+		{"password", "b866924125c8eebb9debc1db61c538e2"},
+		{"12345678", "83dd2b4dbd04367f28578fdd5b142740"},
+		{"12345678901", "83dd2b4dbd04367f28578fdd5b142740"}, // 超过 8 字节的部分被忽略
+		{"abc", "9c22b4f2088c3465a1562c4b9d6edb04"},         // 不足 8 字节补零
+	}
+	for _, tt := range tests {
+		response, err := vncAuthResponse(tt.password, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(response); got != tt.response {
+			t.Errorf("vncAuthResponse(%q) = %s, want %s", tt.password, got, tt.response)
+		}
+	}
+}
+
+func TestParseRfbVersion(t *testing.T) {
+	tests := []struct {
+		banner  string
+		version string
+		minor   int // 协商使用的次版本，0 表示解析失败
+		reply   string
+	}{
+		{"RFB 003.003\n", "3.3", 3, "RFB 003.003\n"},
+		{"RFB 003.006\n", "3.6", 3, "RFB 003.003\n"}, // UltraVNC
+		{"RFB 003.007\n", "3.7", 7, "RFB 003.007\n"},
+		{"RFB 003.008\n", "3.8", 8, "RFB 003.008\n"},
+		{"RFB 003.889\n", "3.889", 8, "RFB 003.008\n"}, // Apple
+		{"RFB 004.001\n", "", 0, ""},
+		{"SSH-2.0-Open", "", 0, ""},
+		{"RFB 003.00x\n", "", 0, ""},
+	}
+	for _, tt := range tests {
+		major, minor, err := parseRfbVersion([]byte(tt.banner))
+		if tt.minor == 0 {
+			if err == nil {
+				t.Errorf("parseRfbVersion(%q) = %d.%d, want error", tt.banner, major, minor)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRfbVersion(%q): %v", tt.banner, err)
+			continue
+		}
+
+		// 完整握手：客户端回复协商的版本，3.3 由服务端直接指定安全类型
+		var reply [12]byte
+		c := pipeRfb(t, 0, func(server net.Conn) error {
+			io.WriteString(server, tt.banner)
+			if _, err := io.ReadFull(server, reply[:]); err != nil {
+				return err
+			}
+			if tt.minor == 3 {
+				return binary.Write(server, binary.BigEndian, uint32(rfbSecVNCAuth))
+			}
+			_, err := server.Write([]byte{2, rfbSecNone, rfbSecVNCAuth})
+			return err
+		})
+		if err := c.handshake(); err != nil {
+			t.Errorf("%q: handshake: %v", tt.banner, err)
+			continue
+		}
+		if c.Version != tt.version || c.minor != tt.minor || string(reply[:]) != tt.reply {
+			t.Errorf("%q: version %s, negotiated 3.%d, replied %q", tt.banner, c.Version, c.minor, reply)
+		}
+		want := []uint32{rfbSecNone, rfbSecVNCAuth}
+		if tt.minor == 3 {
+			want = []uint32{rfbSecVNCAuth}
+		}
+		if rfbSecurityList(c.Types) != rfbSecurityList(want) {
+			t.Errorf("%q: Types = %v, want %v", tt.banner, c.Types, want)
+		}
+	}
+}
+
+// tightCapability 编码一项 Tight 能力：代码、厂商和名称
+func tightCapability(code uint32, vendor, name string) []byte {
+	capability := binary.BigEndian.AppendUint32(nil, code)
+	capability = append(capability, vendor...)
+	return append(capability, name...)
+}
+
+func TestTightAuthTypes(t *testing.T) {
+	c := pipeRfb(t, 8, func(server net.Conn) error {
+		// 一个隧道能力，客户端应选择 NOTUNNEL
+		message := binary.BigEndian.AppendUint32(nil, 1)
+		message = append(message, tightCapability(0, "TGHT", "NOTUNNEL")...)
+		if _, err := server.Write(message); err != nil {
+			return err
+		}
+		var tunnel uint32
+		if err := binary.Read(server, binary.BigEndian, &tunnel); err != nil {
+			return err
+		}
+		if tunnel != 0 {
+			return errors.New("client did not select NOTUNNEL")
+		}
+
+		message = binary.BigEndian.AppendUint32(nil, 2)
+		message = append(message, tightCapability(rfbTightNoAuth, "STDV", "NOAUTH__")...)
+		message = append(message, tightCapability(rfbTightVNCAuth, "STDV", "VNCAUTH_")...)
+		_, err := server.Write(message)
+		return err
+	})
+	codes, err := c.TightAuthTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rfbTightAuthList(codes) != "none,vnc" {
+		t.Errorf("Tight auth = %v", codes)
+	}
+
+	c = pipeRfb(t, 8, func(server net.Conn) error {
+		return binary.Write(server, binary.BigEndian, uint32(1000))
+	})
+	if _, err := c.TightAuthTypes(); err == nil {
+		t.Error("oversized Tight capability list accepted")
+	}
+}
+
+// blacklistServer 在握手阶段以失败原因拒绝所有连接的服务端
+func blacklistServer(t *testing.T, reason string) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, "RFB 003.008\n")
+			io.ReadFull(conn, make([]byte, 12))
+			message := append([]byte{0}, binary.BigEndian.AppendUint32(nil, uint32(len(reason)))...)
+			conn.Write(append(message, reason...))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestVncBlacklistBackoff(t *testing.T) {
+	port := blacklistServer(t, "Too many security failures")
+	state := core.NewScanState()
+
+	// 连续触发时冷却时间逐次翻倍
+	for _, want := range []time.Duration{30 * time.Second, 60 * time.Second} {
+		_, err := runVncScan(t, port, state, "", "123456")
+		var backoff *core.BackoffError
+		if !errors.As(err, &backoff) {
+			t.Fatalf("err = %v, want *core.BackoffError", err)
+		}
+		if backoff.Delay != want || backoff.Reason != "vnc: Too many security failures" {
+			t.Errorf("backoff = %+v, want delay %s", backoff, want)
+		}
+	}
+
+	// 其他失败原因不是黑名单
+	port = blacklistServer(t, "Connection rejected by user")
+	_, err := runVncScan(t, port, core.NewScanState(), "", "123456")
+	var backoff *core.BackoffError
+	if err == nil || errors.As(err, &backoff) {
+		t.Errorf("err = %v, want a plain handshake failure", err)
+	}
+
+	// 旧版服务端以 SecurityResult 状态 2 表示失败次数过多
+	reason, blacklisted := vncBlacklisted(&rfbAuthFailure{Status: rfbResultTooMany, Reason: "too many authentication failures"})
+	if !blacklisted || reason != "too many authentication failures" {
+		t.Errorf("vncBlacklisted(status 2) = %q, %v", reason, blacklisted)
+	}
+	if _, blacklisted := vncBlacklisted(&rfbAuthFailure{Status: rfbResultFailed, Reason: "Authentication failed"}); blacklisted {
+		t.Error("plain authentication failure treated as blacklist")
+	}
+}
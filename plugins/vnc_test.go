@@ -0,0 +1,233 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zan8in/leo/internal/core"
+)
+
+// ardTestPrime RFC 2409 第二组（1024 位 MODP），与 macOS 使用的密钥长度相同
+var ardTestPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF", 16)
+
+// fakeVncServer 最小的 RFB 服务端（声明 Apple 的 3.889 版本），支持 VNC Authentication 和 ARD
+type fakeVncServer struct {
+	listener    net.Listener
+	types       []byte
+	vncPassword string
+	ardUsers    map[string]string
+
+	mu       sync.Mutex
+	attempts []string // 收到的认证请求，如 "ard admin/secret"、"vnc"
+}
+
+func newFakeVncServer(t *testing.T, types []byte) *fakeVncServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeVncServer{listener: listener, types: types, ardUsers: make(map[string]string)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeVncServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeVncServer) record(attempt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, attempt)
+}
+
+func (s *fakeVncServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "RFB 003.889\n")
+	var version [12]byte
+	if _, err := io.ReadFull(conn, version[:]); err != nil {
+		return
+	}
+	conn.Write(append([]byte{byte(len(s.types))}, s.types...))
+	var selected [1]byte
+	if _, err := io.ReadFull(conn, selected[:]); err != nil {
+		return
+	}
+
+	var ok bool
+	switch selected[0] {
+	case rfbSecVNCAuth:
+		challenge := bytes.Repeat([]byte{0x5a}, 16)
+		conn.Write(challenge)
+		response := make([]byte, 16)
+		if _, err := io.ReadFull(conn, response); err != nil {
+			return
+		}
+		// 按配置的密码计算期望的应答
+		expected, _ := vncAuthResponse(s.vncPassword, challenge)
+		ok = bytes.Equal(response, expected)
+		s.record("vnc")
+	case rfbSecARD:
+		username, password, err := serveARD(conn, ardTestPrime)
+		if err != nil {
+			return
+		}
+		want, known := s.ardUsers[username]
+		ok = known && want == password
+		s.record("ard " + username + "/" + password)
+	default:
+		return
+	}
+
+	if !ok {
+		binary.Write(conn, binary.BigEndian, uint32(rfbResultFailed))
+		reason := "Authentication failed"
+		binary.Write(conn, binary.BigEndian, uint32(len(reason)))
+		io.WriteString(conn, reason)
+		return
+	}
+	binary.Write(conn, binary.BigEndian, uint32(rfbResultOK))
+	var shared [1]byte
+	if _, err := io.ReadFull(conn, shared[:]); err != nil {
+		return
+	}
+	serverInit := make([]byte, 20)
+	binary.BigEndian.PutUint16(serverInit[0:2], 1920)
+	binary.BigEndian.PutUint16(serverInit[2:4], 1080)
+	name := "test desktop"
+	serverInit = binary.BigEndian.AppendUint32(serverInit, uint32(len(name)))
+	conn.Write(append(serverInit, name...))
+}
+
+// serveARD 服务端一侧的 ARD 交换：发送 DH 参数，用自己的私钥计算共享密钥并解密凭据
+func serveARD(conn net.Conn, prime *big.Int) (string, string, error) {
+	keyLength := len(prime.Bytes())
+	generator := big.NewInt(2)
+	private := big.NewInt(0x1234567890abcdef)
+	public := new(big.Int).Exp(generator, private, prime)
+
+	message := binary.BigEndian.AppendUint16(nil, 2)
+	message = binary.BigEndian.AppendUint16(message, uint16(keyLength))
+	message = append(message, prime.FillBytes(make([]byte, keyLength))...)
+	message = append(message, public.FillBytes(make([]byte, keyLength))...)
+	if _, err := conn.Write(message); err != nil {
+		return "", "", err
+	}
+
+	response := make([]byte, ardFieldSize*2+keyLength)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return "", "", err
+	}
+	clientPublic := new(big.Int).SetBytes(response[ardFieldSize*2:])
+	shared := new(big.Int).Exp(clientPublic, private, prime)
+	key := md5.Sum(shared.FillBytes(make([]byte, keyLength)))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", "", err
+	}
+	credentials := response[:ardFieldSize*2]
+	for i := 0; i < len(credentials); i += aes.BlockSize {
+		block.Decrypt(credentials[i:i+aes.BlockSize], credentials[i:i+aes.BlockSize])
+	}
+	username, _, _ := bytes.Cut(credentials[:ardFieldSize], []byte{0})
+	password, _, _ := bytes.Cut(credentials[ardFieldSize:], []byte{0})
+	return string(username), string(password), nil
+}
+
+// runVncScan 对本地服务端执行一次 VncScan，返回上报的结果
+func runVncScan(t *testing.T, port int, state *core.ScanState, username, password string) ([]*core.ScanResult, error) {
+	t.Helper()
+	var results []*core.ScanResult
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := VncScan(&core.HostInfo{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Timeout:  2 * time.Second,
+		Service:  "vnc",
+		Username: username,
+		Password: password,
+		Context:  ctx,
+		State:    state,
+		Handler:  func(result *core.ScanResult) { results = append(results, result) },
+	})
+	return results, err
+}
+
+func TestVncARDFallback(t *testing.T) {
+	// macOS 同时提供 ARD 和 VNC Authentication
+	server := newFakeVncServer(t, []byte{rfbSecARD, rfbSecVNCAuth})
+	server.ardUsers["admin"] = "ardpass"
+	server.vncPassword = "vncpass"
+	state := core.NewScanState()
+
+	results, err := runVncScan(t, server.port(), state, "admin", "ardpass")
+	if err != nil || len(results) != 1 || results[0].Username != "admin" || results[0].Metadata["auth"] != vncAuthARD {
+		t.Fatalf("ARD login: results %+v, err %v", results, err)
+	}
+
+	// ARD 失败后以同一密码尝试 VNC Authentication，结果不记录用户名
+	results, err = runVncScan(t, server.port(), state, "admin", "vncpass")
+	if err != nil || len(results) != 1 {
+		t.Fatalf("VNC fallback: results %+v, err %v", results, err)
+	}
+	if result := results[0]; result.Username != "" || result.Password != "vncpass" || result.Metadata["auth"] != vncAuthVNC {
+		t.Errorf("VNC fallback result = %+v", result)
+	}
+
+	// 同一密码对其他用户名只尝试 ARD
+	if _, err := runVncScan(t, server.port(), state, "root", "vncpass"); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("second username: err %v, want ARD failure", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	want := []string{"ard admin/ardpass", "ard admin/vncpass", "vnc", "ard root/vncpass"}
+	if strings.Join(server.attempts, "|") != strings.Join(want, "|") {
+		t.Errorf("server saw %q, want %q", server.attempts, want)
+	}
+}
+
+func TestVncUnsupportedVeNCrypt(t *testing.T) {
+	// 未配置证书的 TigerVNC 只提供匿名 TLS 子类型
+	target := &vncTarget{
+		Types:    []uint32{rfbSecVeNCrypt},
+		VeNCrypt: []uint32{vencryptTLSVnc, vencryptTLSPlain, vencryptX509Plain},
+	}
+	if method, ok := target.Method(); !ok || method.Sub != vencryptX509Plain {
+		t.Errorf("Method() = %+v, %v, want x509-plain", method, ok)
+	}
+	if got := target.Metadata()["vencrypt_unsupported"]; got != "tls-vnc,tls-plain" {
+		t.Errorf("vencrypt_unsupported = %q", got)
+	}
+
+	target.VeNCrypt = []uint32{vencryptTLSVnc, vencryptTLSPlain}
+	if _, ok := target.Method(); ok {
+		t.Error("anonymous TLS subtypes reported as supported")
+	}
+}